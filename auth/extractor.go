@@ -0,0 +1,71 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenExtractor returns raw token from the request or empty string if not present
+type TokenExtractor func(c *gin.Context) string
+
+// FromAuthorizationHeader token from "Authorization: Bearer <token>" header. Token without Bearer prefix is accepted as is
+func FromAuthorizationHeader() TokenExtractor {
+	return FromHeader("Authorization", "Bearer ")
+}
+
+// FromHeader token from header with optional (case insensitive) prefix stripped
+func FromHeader(header, prefix string) TokenExtractor {
+	return func(c *gin.Context) string {
+		token := strings.TrimSpace(c.GetHeader(header))
+		if prefix != "" && len(token) >= len(prefix) && strings.EqualFold(token[:len(prefix)], prefix) {
+			token = strings.TrimSpace(token[len(prefix):])
+		}
+		return token
+	}
+}
+
+// FromQuery token from query parameter (e.g. websocket or stream URLs)
+func FromQuery(param string) TokenExtractor {
+	return func(c *gin.Context) string {
+		return c.Query(param)
+	}
+}
+
+// FromCookie token from cookie
+func FromCookie(name string) TokenExtractor {
+	return func(c *gin.Context) string {
+		if name == "" {
+			return ""
+		}
+		cookie, err := c.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie
+	}
+}
+
+// extractToken first non empty token in order of extractors
+func extractToken(c *gin.Context, extractors []TokenExtractor) string {
+	for _, extract := range extractors {
+		if token := extract(c); token != "" {
+			return token
+		}
+	}
+	return ""
+}
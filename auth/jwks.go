@@ -0,0 +1,409 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/config"
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrJWKSSource neither JWKS URL nor file configured
+	ErrJWKSSource = errors.New("jwks url or file required")
+	// ErrJWKSKeyNotFound no key with token kid (even after reloading keys)
+	ErrJWKSKeyNotFound = errors.New("signing key not found")
+	// ErrJWKSAlgorithm token algorithm not accepted or doesn't match the key
+	ErrJWKSAlgorithm = errors.New("signing algorithm not accepted")
+	// ErrJWKSTooLarge downloaded key set is larger than maxJWKSSize
+	ErrJWKSTooLarge = errors.New("jwks response too large")
+)
+
+const (
+	// maxJWKSSize limit of the downloaded key set, real key sets are a few KB
+	maxJWKSSize = 1 << 20
+	// jwksTimeout download timeout, also applies to a JWKSHTTPClient without timeout
+	jwksTimeout = 10 * time.Second
+)
+
+// DefaultJWKSAlgorithms accepted signing algorithms if not configured
+var DefaultJWKSAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// JWKSOptions settings of the JWKS key set
+type JWKSOptions struct {
+	Log             mclog.Logger
+	URL             string
+	File            string
+	RefreshInterval time.Duration // keys are reloaded when older than this
+	MinRefresh      time.Duration // minimum time between reloads triggered by unknown kid
+	Algorithms      []string
+	HTTPClient      *http.Client
+}
+
+// JWKSOption a single JWKS option
+type JWKSOption func(*JWKSOptions)
+
+// JWKSLog - logs failed reloads
+func JWKSLog(log mclog.Logger) JWKSOption {
+	return func(args *JWKSOptions) {
+		args.Log = log
+	}
+}
+
+// JWKSURL - load keys from URL (e.g. https://issuer/.well-known/jwks.json)
+func JWKSURL(url string) JWKSOption {
+	return func(args *JWKSOptions) {
+		args.URL = url
+	}
+}
+
+// JWKSFile - load keys from local JSON file
+func JWKSFile(path string) JWKSOption {
+	return func(args *JWKSOptions) {
+		args.File = path
+	}
+}
+
+// JWKSRefresh - reload keys every refreshInterval (default 1 hour). Unknown kid reloads keys at most every minRefresh (default 30 seconds)
+func JWKSRefresh(refreshInterval, minRefresh time.Duration) JWKSOption {
+	return func(args *JWKSOptions) {
+		args.RefreshInterval = refreshInterval
+		args.MinRefresh = minRefresh
+	}
+}
+
+// JWKSAlgorithms - accepted signing algorithms (RS256/384/512, PS256/384/512, ES256/384/512, EdDSA)
+func JWKSAlgorithms(algorithms ...string) JWKSOption {
+	return func(args *JWKSOptions) {
+		args.Algorithms = algorithms
+	}
+}
+
+// JWKSHTTPClient - client used to download keys. Downloads time out after 10s even if the client has no timeout
+func JWKSHTTPClient(client *http.Client) JWKSOption {
+	return func(args *JWKSOptions) {
+		args.HTTPClient = client
+	}
+}
+
+// jwk single JSON web key
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksKey parsed public key
+type jwksKey struct {
+	kid string
+	alg string // optional, from JWKS
+	key interface{}
+}
+
+// JWKS - public keys from JWKS URL or file selected by token kid. Use Keyfunc as jwt.Keyfunc
+type JWKS struct {
+	options      JWKSOptions
+	mutex        sync.RWMutex
+	keys         []jwksKey
+	loaded       time.Time
+	refreshMutex sync.Mutex
+	lastRefresh  time.Time
+}
+
+// NewJWKS loads the key set from URL or file
+func NewJWKS(opts ...JWKSOption) (*JWKS, error) {
+	args := JWKSOptions{
+		RefreshInterval: time.Hour,
+		MinRefresh:      30 * time.Second,
+		Algorithms:      DefaultJWKSAlgorithms,
+		HTTPClient:      &http.Client{Timeout: jwksTimeout},
+	}
+	for _, op := range opts {
+		op(&args)
+	}
+	if args.URL == "" && args.File == "" {
+		return nil, ErrJWKSSource
+	}
+	j := &JWKS{options: args}
+	if err := j.Refresh(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// NewJWKSFromConfig key set from jwks_url or jwks_file, jwks_refresh and algorithms of jwt_token section
+func NewJWKSFromConfig(conf *config.YamlConfig, opts ...JWKSOption) (*JWKS, error) {
+	section := conf.JWTToken
+	configured := []JWKSOption{JWKSURL(section.JWKSURL)}
+	if section.JWKSURL == "" {
+		configured = []JWKSOption{JWKSFile(section.JWKSFile)}
+	}
+	if section.JWKSRefresh > 0 {
+		configured = append(configured, func(args *JWKSOptions) {
+			args.RefreshInterval = section.JWKSRefresh
+		})
+	}
+	if len(section.Algorithms) > 0 {
+		configured = append(configured, JWKSAlgorithms(section.Algorithms...))
+	}
+	return NewJWKS(append(configured, opts...)...)
+}
+
+// Keyfunc returns public key for the token kid. Keys are reloaded when stale or kid is not known (key rotation).
+// Failed reloads keep the previous keys
+func (j *JWKS) Keyfunc(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if !j.accepts(alg) {
+		return nil, ErrJWKSAlgorithm
+	}
+	kid, _ := token.Header["kid"].(string)
+
+	j.mutex.RLock()
+	stale := time.Since(j.loaded) > j.options.RefreshInterval
+	j.mutex.RUnlock()
+	if stale {
+		j.reload(false)
+	}
+
+	key, err := j.lookup(kid, alg)
+	if err == ErrJWKSKeyNotFound && j.reload(true) {
+		key, err = j.lookup(kid, alg)
+	}
+	return key, err
+}
+
+// Refresh reloads keys now
+func (j *JWKS) Refresh() error {
+	keys, err := j.load()
+	if err != nil {
+		return err
+	}
+	j.mutex.Lock()
+	j.keys = keys
+	j.loaded = time.Now()
+	j.mutex.Unlock()
+	return nil
+}
+
+// reload keys at most every MinRefresh, true if keys were reloaded (or are fresh)
+func (j *JWKS) reload(unknownKid bool) bool {
+	j.refreshMutex.Lock()
+	defer j.refreshMutex.Unlock()
+	j.mutex.RLock()
+	loaded := j.loaded
+	j.mutex.RUnlock()
+	if !unknownKid && time.Since(loaded) <= j.options.RefreshInterval {
+		// reloaded by another request meanwhile
+		return true
+	}
+	// don't hammer the source with unknown kids or while it's failing
+	if time.Since(j.lastRefresh) < j.options.MinRefresh {
+		return false
+	}
+	j.lastRefresh = time.Now()
+	if err := j.Refresh(); err != nil {
+		if j.options.Log != nil {
+			j.options.Log.Error("failed to reload jwks, keeping previous keys", err)
+		}
+		return false
+	}
+	return true
+}
+
+// lookup key by kid (any key of matching type if token has no kid)
+func (j *JWKS) lookup(kid, alg string) (interface{}, error) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	for _, k := range j.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if (k.alg != "" && k.alg != alg) || !keyMatchesAlg(k.key, alg) {
+			if kid != "" {
+				return nil, ErrJWKSAlgorithm
+			}
+			continue
+		}
+		return k.key, nil
+	}
+	return nil, ErrJWKSKeyNotFound
+}
+
+func (j *JWKS) accepts(alg string) bool {
+	for _, a := range j.options.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// load and parse keys, keys that are not for signatures or of unsupported type are skipped
+func (j *JWKS) load() ([]jwksKey, error) {
+	var data []byte
+	var err error
+	if j.options.URL != "" {
+		data, err = j.download()
+	} else {
+		data, err = ioutil.ReadFile(j.options.File)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]jwksKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			if j.options.Log != nil {
+				j.options.Log.Warn("skipping jwks key", k.Kid, err)
+			}
+			continue
+		}
+		keys = append(keys, jwksKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	return keys, nil
+}
+
+func (j *JWKS) download() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.options.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks download failed: %v", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxJWKSSize {
+		return nil, ErrJWKSTooLarge
+	}
+	return data, nil
+}
+
+// publicKey rsa, ecdsa or ed25519 public key of the JWK
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %v", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", k.Kty)
+	}
+}
+
+// keyMatchesAlg true if key can verify tokens signed with alg
+func keyMatchesAlg(key interface{}, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return k.Curve == elliptic.P256()
+		case "ES384":
+			return k.Curve == elliptic.P384()
+		case "ES512":
+			return k.Curve == elliptic.P521()
+		}
+	case ed25519.PublicKey:
+		return alg == SigningMethodEdDSA.Alg()
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/config"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// testJWKS served key set that can be rotated
+type testJWKS struct {
+	mutex sync.Mutex
+	keys  []map[string]string
+	fail  bool
+	hits  int
+}
+
+func (s *testJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hits++
+	if s.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func (s *testJWKS) set(keys ...map[string]string) {
+	s.mutex.Lock()
+	s.keys = keys
+	s.mutex.Unlock()
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kid": kid, "kty": "RSA", "alg": "RS256", "use": "sig",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kid": kid, "kty": "EC", "crv": "P-256", "x": b64(key.X.Bytes()), "y": b64(key.Y.Bytes())}
+}
+
+func edJWK(kid string, key ed25519.PublicKey) map[string]string {
+	return map[string]string{"kid": kid, "kty": "OKP", "crv": "Ed25519", "x": b64(key)}
+}
+
+func signWithKid(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	token := jwt.NewWithClaims(method, customClaims{MyProperty: kid})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func parseWith(jwks *JWKS, token string) error {
+	_, err := jwt.ParseWithClaims(token, &customClaims{}, jwks.Keyfunc)
+	var verr *jwt.ValidationError
+	if errors.As(err, &verr) && verr.Inner != nil {
+		return verr.Inner
+	}
+	return err
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	server := &testJWKS{}
+	server.set(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey), edJWK("ed-1", edPub))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	jwks, err := NewJWKS(JWKSURL(ts.URL), JWKSRefresh(time.Hour, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{
+		signWithKid(t, jwt.SigningMethodRS256, "rsa-1", rsaKey),
+		signWithKid(t, jwt.SigningMethodES256, "ec-1", ecKey),
+		signWithKid(t, SigningMethodEdDSA, "ed-1", edKey),
+	} {
+		if err := parseWith(jwks, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// key of kid doesn't match the token algorithm
+	if err := parseWith(jwks, signWithKid(t, jwt.SigningMethodES256, "rsa-1", ecKey)); err != ErrJWKSAlgorithm {
+		t.Fatalf("expected algorithm error, got %v", err)
+	}
+	// HMAC is never accepted with public keys
+	if err := parseWith(jwks, signWithKid(t, jwt.SigningMethodHS256, "rsa-1", []byte("secret"))); err != ErrJWKSAlgorithm {
+		t.Fatalf("expected algorithm error, got %v", err)
+	}
+
+	// rotation: unknown kid reloads keys
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server.set(rsaJWK("rsa-2", rotated))
+	if err := parseWith(jwks, signWithKid(t, jwt.SigningMethodRS256, "rsa-2", rotated)); err != nil {
+		t.Fatal(err)
+	}
+	if err := parseWith(jwks, signWithKid(t, jwt.SigningMethodRS256, "rsa-1", rsaKey)); err != ErrJWKSKeyNotFound {
+		t.Fatalf("expected retired key not found, got %v", err)
+	}
+
+	// failing source keeps previous keys
+	server.mutex.Lock()
+	server.fail = true
+	server.mutex.Unlock()
+	if err := jwks.Refresh(); err == nil {
+		t.Fatal("expected refresh error")
+	}
+	if err := parseWith(jwks, signWithKid(t, jwt.SigningMethodRS256, "rsa-2", rotated)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWKSMinRefresh(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	server := &testJWKS{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	jwks, err := NewJWKS(JWKSURL(ts.URL), JWKSRefresh(time.Hour, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := parseWith(jwks, signWithKid(t, SigningMethodEdDSA, "unknown", edKey)); err != ErrJWKSKeyNotFound {
+			t.Fatalf("expected key not found, got %v", err)
+		}
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.hits != 2 {
+		t.Fatalf("expected initial load and a single reload, got %v", server.hits)
+	}
+}
+
+func TestJWKSTooLarge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[`))
+		w.Write(bytes.Repeat([]byte(" "), maxJWKSSize))
+		w.Write([]byte(`]}`))
+	}))
+	defer ts.Close()
+
+	if _, err := NewJWKS(JWKSURL(ts.URL), JWKSHTTPClient(http.DefaultClient)); !errors.Is(err, ErrJWKSTooLarge) {
+		t.Fatalf("expected too large error, got %v", err)
+	}
+}
+
+func TestJWKSFromConfig(t *testing.T) {
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "jwks.json")
+	data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{edJWK("ed-1", edPub)}})
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.YamlConfig{
+		JWTToken: config.JWTTokenSection{
+			Enabled:    true,
+			JWKSFile:   file,
+			Algorithms: []string{"EdDSA"},
+		},
+	}
+	jwks, err := NewJWKSFromConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.GET("/claims", JwtClaimsMiddleware(conf, func() jwt.Claims { return &customClaims{} }, nil, jwks.Keyfunc), func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/claims", nil)
+	req.Header.Set("Authorization", "Bearer "+signWithKid(t, SigningMethodEdDSA, "ed-1", edKey))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	if _, err := NewJWKSFromConfig(&config.YamlConfig{}); err != ErrJWKSSource {
+		t.Fatalf("expected source error, got %v", err)
+	}
+}
@@ -16,7 +16,8 @@ package auth
 
 import (
 	"errors"
-	"net/http"
+	"reflect"
+	"time"
 
 	"github.com/chryscloud/go-microkit-plugins/config"
 	jwt "github.com/dgrijalva/jwt-go"
@@ -36,6 +37,8 @@ const (
 var (
 	// ErrClaimNotFound when claim is expected in the gin context
 	ErrClaimNotFound = errors.New("claim not found in context")
+	// ErrSigningMethod token signed with unexpected method
+	ErrSigningMethod = errors.New("unexpected signing method")
 )
 
 // NewJWTToken - method for generating new jwt tokens
@@ -48,55 +51,98 @@ func NewJWTToken(key []byte, method jwt.SigningMethod, claims jwt.Claims) (strin
 	return tokenString, nil
 }
 
-// JwtMiddleware for Gin server if enabled
+// JwtMiddleware for Gin server if enabled. newClaims is only a template, each request is parsed into a new zero value of its type.
+// See JwtClaimsMiddleware for claims factory and token extractors
 func JwtMiddleware(conf *config.YamlConfig, newClaims jwt.Claims, method jwt.SigningMethod, keyFunc jwt.Keyfunc) gin.HandlerFunc {
+	// method was never checked by this middleware, keep accepting any signing method the keyFunc accepts
+	return JwtClaimsMiddleware(conf, claimsFactory(newClaims), nil, keyFunc)
+}
+
+// JwtOptions - JwtClaimsMiddleware options
+type JwtOptions struct {
+	Extractors []TokenExtractor
+	Validation TokenValidation
+}
+
+// JwtOption a single JwtClaimsMiddleware option
+type JwtOption func(*JwtOptions)
+
+// JwtExtractors - where the token is looked for, in order. Default is Authorization header followed by cookie_name cookie
+func JwtExtractors(extractors ...TokenExtractor) JwtOption {
+	return func(args *JwtOptions) {
+		args.Extractors = extractors
+	}
+}
+
+// JwtIssuers - accepted iss values (default jwt_token issuers)
+func JwtIssuers(issuers ...string) JwtOption {
+	return func(args *JwtOptions) {
+		args.Validation.Issuers = issuers
+	}
+}
+
+// JwtAudiences - token aud must contain one of audiences (default jwt_token audiences)
+func JwtAudiences(audiences ...string) JwtOption {
+	return func(args *JwtOptions) {
+		args.Validation.Audiences = audiences
+	}
+}
+
+// JwtLeeway - allowed clock skew for exp, nbf and iat, e.g. for edge devices with bad clocks (default jwt_token leeway)
+func JwtLeeway(leeway time.Duration) JwtOption {
+	return func(args *JwtOptions) {
+		args.Validation.Leeway = leeway
+	}
+}
+
+// JwtMaxAge - reject tokens issued (iat) longer than maxAge ago (default jwt_token max_age)
+func JwtMaxAge(maxAge time.Duration) JwtOption {
+	return func(args *JwtOptions) {
+		args.Validation.MaxAge = maxAge
+	}
+}
+
+// JwtRequiredClaims - claims every token must contain (default jwt_token required_claims)
+func JwtRequiredClaims(claims ...string) JwtOption {
+	return func(args *JwtOptions) {
+		args.Validation.RequiredClaims = claims
+	}
+}
+
+// JwtRevocations - reject tokens revoked in store (e.g. TokenService logout)
+func JwtRevocations(store RevocationStore) JwtOption {
+	return func(args *JwtOptions) {
+		args.Validation.Revocations = store
+	}
+}
+
+// JwtClaimsMiddleware for Gin server if enabled. Every request is parsed into new claims from newClaims (e.g. func() jwt.Claims { return &UserClaim{} }).
+// Tokens signed with other method than method are rejected (nil accepts any method keyFunc accepts).
+// Failed requests get {"error": "...", "code": "..."} response, see TokenError
+func JwtClaimsMiddleware(conf *config.YamlConfig, newClaims func() jwt.Claims, method jwt.SigningMethod, keyFunc jwt.Keyfunc, opts ...JwtOption) gin.HandlerFunc {
+	args := &JwtOptions{
+		Extractors: []TokenExtractor{FromAuthorizationHeader(), FromCookie(conf.JWTToken.CookieName)},
+		Validation: TokenValidation{
+			Issuers:        conf.JWTToken.Issuers,
+			Audiences:      conf.JWTToken.Audiences,
+			Leeway:         conf.JWTToken.Leeway,
+			MaxAge:         conf.JWTToken.MaxAge,
+			RequiredClaims: conf.JWTToken.RequiredClaims,
+		},
+	}
+	for _, op := range opts {
+		op(args)
+	}
 	return func(c *gin.Context) {
 		if conf.JWTToken.Enabled {
-			reqToken := c.GetHeader("Authorization")
+			reqToken := extractToken(c, args.Extractors)
 			if reqToken == "" {
-				// also check cookies if not found in header Authorization
-				cook, err := c.Cookie(conf.JWTToken.CookieName)
-				if err != nil {
-					c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization failed"})
-					c.Abort()
-					return
-				}
-				reqToken = cook
-			}
-			token, err := jwt.ParseWithClaims(reqToken, newClaims, func(token *jwt.Token) (interface{}, error) {
-				// since we only use the one private key to sign the tokens,
-				// we also only use its public counter part to verify
-				return keyFunc(token)
-			})
-			if err != nil {
-				if e, ok := err.(*jwt.ValidationError); ok {
-					switch {
-					case e.Errors&jwt.ValidationErrorMalformed != 0:
-						c.JSON(http.StatusBadRequest, gin.H{"error": "JWT Token is malformed"})
-						c.Abort()
-						return
-					case e.Errors&jwt.ValidationErrorExpired != 0:
-						c.JSON(http.StatusUnauthorized, gin.H{"error": "JWT Token is expired"})
-						c.Abort()
-						return
-					case e.Errors&jwt.ValidationErrorNotValidYet != 0:
-						c.JSON(http.StatusUnauthorized, gin.H{"error": "token is not valid yet"})
-						c.Abort()
-						return
-					case e.Inner != nil:
-						c.JSON(http.StatusUnauthorized, gin.H{"error": e.Inner.Error()})
-						c.Abort()
-						return
-					}
-				}
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "internal error"})
-				c.Abort()
+				abortWithTokenError(c, ErrTokenMissing)
 				return
 			}
-
-			if !token.Valid {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token"})
-				c.Abort()
+			token, err := ParseToken(reqToken, newClaims(), method, keyFunc, args.Validation)
+			if err != nil {
+				abortWithTokenError(c, err)
 				return
 			}
 
@@ -106,3 +152,36 @@ func JwtMiddleware(conf *config.YamlConfig, newClaims jwt.Claims, method jwt.Sig
 		c.Next()
 	}
 }
+
+// GetClaims typed claims set by JwtClaimsMiddleware, e.g. GetClaims[*UserClaim](c)
+func GetClaims[T jwt.Claims](c *gin.Context) (T, error) {
+	var empty T
+	claims, ok := c.Get(JWTClaimsContextKey)
+	if !ok {
+		return empty, ErrClaimNotFound
+	}
+	typed, ok := claims.(T)
+	if !ok {
+		return empty, ErrClaimNotFound
+	}
+	return typed, nil
+}
+
+// claimsFactory new zero value of claims type for every call
+func claimsFactory(claims jwt.Claims) func() jwt.Claims {
+	if _, ok := claims.(jwt.MapClaims); ok {
+		return func() jwt.Claims {
+			return jwt.MapClaims{}
+		}
+	}
+	t := reflect.TypeOf(claims)
+	if t.Kind() != reflect.Ptr {
+		// value claims can't be parsed into, jwt-go fails the same way as before
+		return func() jwt.Claims {
+			return claims
+		}
+	}
+	return func() jwt.Claims {
+		return reflect.New(t.Elem()).Interface().(jwt.Claims)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/chryscloud/go-microkit-plugins/config"
@@ -154,3 +155,118 @@ func TestInvalidKey(t *testing.T) {
 	router.ServeHTTP(w, req)
 	assert.Equal(t, 401, w.Code)
 }
+
+func setupClaimsRouter(conf *config.YamlConfig, opts ...JwtOption) *gin.Engine {
+	r := gin.New()
+	keys := func(token *jwt.Token) (interface{}, error) {
+		return []byte(conf.JWTToken.SecretKey), nil
+	}
+	newClaims := func() jwt.Claims { return &customClaims{} }
+	r.GET("/claims", JwtClaimsMiddleware(conf, newClaims, jwt.SigningMethodHS256, keys, opts...), func(c *gin.Context) {
+		claims, err := GetClaims[*customClaims](c)
+		if err != nil {
+			c.String(404, err.Error())
+			return
+		}
+		c.String(200, claims.MyProperty)
+	})
+	return r
+}
+
+func TestJwtClaimsExtractors(t *testing.T) {
+	conf := &config.YamlConfig{
+		JWTToken: config.JWTTokenSection{
+			Enabled:    true,
+			SecretKey:  "my test secret key here",
+			CookieName: "testcookie",
+		},
+	}
+	headerToken, _ := NewJWTToken([]byte(conf.JWTToken.SecretKey), jwt.SigningMethodHS256, customClaims{MyProperty: "header"})
+	queryToken, _ := NewJWTToken([]byte(conf.JWTToken.SecretKey), jwt.SigningMethodHS256, customClaims{MyProperty: "query"})
+	cookieToken, _ := NewJWTToken([]byte(conf.JWTToken.SecretKey), jwt.SigningMethodHS256, customClaims{MyProperty: "cookie"})
+
+	request := func(router *gin.Engine, header, query, cookie string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/claims?access_token="+query, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "testcookie", Value: cookie})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// default: Authorization header (Bearer prefix stripped), then cookie
+	router := setupClaimsRouter(conf)
+	w := request(router, "Bearer "+headerToken, "", cookieToken)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "header", w.Body.String())
+	w = request(router, "", queryToken, cookieToken)
+	assert.Equal(t, "cookie", w.Body.String())
+	w = request(router, "", queryToken, "")
+	assert.Equal(t, 401, w.Code)
+
+	// custom order
+	router = setupClaimsRouter(conf, JwtExtractors(FromQuery("access_token"), FromCookie("testcookie"), FromAuthorizationHeader()))
+	w = request(router, "Bearer "+headerToken, queryToken, cookieToken)
+	assert.Equal(t, "query", w.Body.String())
+	w = request(router, "bearer "+headerToken, "", cookieToken)
+	assert.Equal(t, "cookie", w.Body.String())
+	w = request(router, "bearer "+headerToken, "", "")
+	assert.Equal(t, "header", w.Body.String())
+}
+
+func TestJwtClaimsSigningMethod(t *testing.T) {
+	conf := &config.YamlConfig{
+		JWTToken: config.JWTTokenSection{
+			Enabled:   true,
+			SecretKey: "my test secret key here",
+		},
+	}
+	token, _ := NewJWTToken([]byte(conf.JWTToken.SecretKey), jwt.SigningMethodHS512, customClaims{MyProperty: "hs512"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/claims", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	setupClaimsRouter(conf).ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestJwtClaimsConcurrent(t *testing.T) {
+	conf := &config.YamlConfig{
+		JWTToken: config.JWTTokenSection{
+			Enabled:   true,
+			SecretKey: "my test secret key here",
+		},
+	}
+	router := setupClaimsRouter(conf)
+	legacy := setupRouter(conf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			property := fmt.Sprintf("user-%d", i)
+			token, _ := NewJWTToken([]byte(conf.JWTToken.SecretKey), jwt.SigningMethodHS256, customClaims{MyProperty: property})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/claims", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			router.ServeHTTP(w, req)
+			if w.Body.String() != property {
+				t.Errorf("expected claims of %v, got %v", property, w.Body.String())
+			}
+
+			// JwtMiddleware no longer shares claims between requests
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/test/ping", nil)
+			req.Header.Set("Authorization", token)
+			legacy.ServeHTTP(w, req)
+			if w.Code != 200 {
+				t.Errorf("expected 200, got %v", w.Code)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationStore - denylist of revoked token IDs (jti) and token families. Entries can be dropped after expiresAt,
+// when tokens they refer to are expired anyway
+type RevocationStore interface {
+	// Revoke adds id to the denylist, false if it was already revoked (must be atomic for refresh token reuse detection)
+	Revoke(id string, expiresAt time.Time) (bool, error)
+	IsRevoked(id string) (bool, error)
+}
+
+// MemoryRevocationStore - in-memory RevocationStore for a single instance
+type MemoryRevocationStore struct {
+	mutex     sync.Mutex
+	revoked   map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewMemoryRevocationStore empty in-memory store
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revoked:   make(map[string]time.Time),
+		lastPrune: time.Now(),
+	}
+}
+
+// Revoke adds id to the denylist until expiresAt
+func (s *MemoryRevocationStore) Revoke(id string, expiresAt time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if exp, ok := s.revoked[id]; ok && now.Before(exp) {
+		return false, nil
+	}
+	s.revoked[id] = expiresAt
+	if now.Sub(s.lastPrune) > time.Minute {
+		s.prune(now)
+	}
+	return true, nil
+}
+
+// IsRevoked true if id is on the denylist
+func (s *MemoryRevocationStore) IsRevoked(id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	exp, ok := s.revoked[id]
+	return ok && time.Now().Before(exp), nil
+}
+
+// prune expired entries
+func (s *MemoryRevocationStore) prune(now time.Time) {
+	for id, exp := range s.revoked {
+		if !now.Before(exp) {
+			delete(s.revoked, id)
+		}
+	}
+	s.lastPrune = now
+}
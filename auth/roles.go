@@ -0,0 +1,198 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrForbidden user doesn't have the required roles
+	ErrForbidden = &TokenError{Status: http.StatusForbidden, Code: "forbidden", Message: "insufficient permissions"}
+	// ErrUserDisabled token of a disabled user
+	ErrUserDisabled = &TokenError{Status: http.StatusForbidden, Code: "user_disabled", Message: "user is disabled"}
+)
+
+// RoleClaims - claims with roles, implemented by models/jwt.UserClaim (and claims embedding it)
+type RoleClaims interface {
+	GetRoles() []string
+	IsEnabled() bool
+}
+
+// RequireRoles allows requests whose claims (set by JWT middleware) contain all of the roles
+func RequireRoles(log mclog.Logger, roles ...string) gin.HandlerFunc {
+	rule := &PolicyRule{Roles: roles}
+	return func(c *gin.Context) {
+		authorize(c, rule, log)
+	}
+}
+
+// RequireAnyRole allows requests whose claims (set by JWT middleware) contain at least one of the roles
+func RequireAnyRole(log mclog.Logger, roles ...string) gin.HandlerFunc {
+	rule := &PolicyRule{AnyRole: roles}
+	return func(c *gin.Context) {
+		authorize(c, rule, log)
+	}
+}
+
+// PolicyRule - roles required for requests matching Path
+type PolicyRule struct {
+	Path    string   `yaml:"path"`     // regular expression matching the whole request path, e.g. /api/v1/devices/.*
+	Methods []string `yaml:"methods"`  // HTTP methods, all if empty
+	Roles   []string `yaml:"roles"`    // all of them required
+	AnyRole []string `yaml:"any_role"` // at least one of them required
+
+	pattern *regexp.Regexp
+}
+
+// Policy - route pattern to required roles. The first matching rule applies
+type Policy struct {
+	Rules       []PolicyRule `yaml:"rules"`
+	DefaultDeny bool         `yaml:"default_deny"` // deny requests no rule matches, allowed by default
+}
+
+// NewPolicy compiles rule path patterns
+func NewPolicy(policy Policy) (*Policy, error) {
+	for i := range policy.Rules {
+		pattern, err := regexp.Compile("^(?:" + policy.Rules[i].Path + ")$")
+		if err != nil {
+			return nil, err
+		}
+		policy.Rules[i].pattern = pattern
+	}
+	return &policy, nil
+}
+
+// LoadPolicy reads policy from YAML file:
+//
+//	default_deny: true
+//	rules:
+//	  - path: /api/v1/admin/.*
+//	    roles: [admin]
+//	  - path: /api/v1/devices/.*
+//	    methods: [POST, PUT, DELETE]
+//	    any_role: [admin, operator]
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, err
+	}
+	return NewPolicy(policy)
+}
+
+// PolicyMiddleware enforces policy on requests authenticated by JWT middleware
+func PolicyMiddleware(policy *Policy, log mclog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule := policy.match(c.Request.Method, c.Request.URL.Path)
+		if rule == nil {
+			if policy.DefaultDeny {
+				if log != nil {
+					log.Warn("authorization denied", "no policy rule", "method", c.Request.Method, "path", c.Request.URL.Path)
+				}
+				abortWithTokenError(c, ErrForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+		authorize(c, rule, log)
+	}
+}
+
+// match first rule matching request
+func (p *Policy) match(method, path string) *PolicyRule {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if len(rule.Methods) > 0 && !containsFold(rule.Methods, method) {
+			continue
+		}
+		if rule.pattern != nil && rule.pattern.MatchString(path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// authorize checks claims in gin context against the rule
+func authorize(c *gin.Context, rule *PolicyRule, log mclog.Logger) {
+	value, ok := c.Get(JWTClaimsContextKey)
+	claims, isRoleClaims := value.(RoleClaims)
+	if !ok || !isRoleClaims {
+		if log != nil {
+			log.Warn("authorization denied", "no role claims", "path", c.Request.URL.Path)
+		}
+		abortWithTokenError(c, ErrTokenMissing)
+		return
+	}
+	user := ""
+	if identity, ok := value.(interface{ GetID() string }); ok {
+		user = identity.GetID()
+	}
+
+	err := rule.check(claims)
+	if err != nil {
+		if log != nil {
+			log.Warn("authorization denied", "user", user, "method", c.Request.Method, "path", c.Request.URL.Path, "rule", rule.Path, err)
+		}
+		abortWithTokenError(c, err)
+		return
+	}
+	if log != nil {
+		log.Info("authorization granted", "user", user, "method", c.Request.Method, "path", c.Request.URL.Path, "rule", rule.Path)
+	}
+	c.Next()
+}
+
+// check claims roles, nil if allowed
+func (rule *PolicyRule) check(claims RoleClaims) error {
+	if !claims.IsEnabled() {
+		return ErrUserDisabled
+	}
+	roles := claims.GetRoles()
+	for _, required := range rule.Roles {
+		if !contains(roles, required) {
+			return ErrForbidden
+		}
+	}
+	if len(rule.AnyRole) == 0 {
+		return nil
+	}
+	for _, role := range rule.AnyRole {
+		if contains(roles, role) {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
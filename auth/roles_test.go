@@ -0,0 +1,136 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chryscloud/go-microkit-plugins/config"
+	jwtmodels "github.com/chryscloud/go-microkit-plugins/models/jwt"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const testPolicy = `
+default_deny: true
+rules:
+  - path: /admin/.*
+    roles: [admin]
+  - path: /devices/.*
+    methods: [POST, DELETE]
+    any_role: [admin, operator]
+  - path: /devices/.*
+`
+
+func roleRequest(t *testing.T, r *gin.Engine, method, path string, claims jwtmodels.UserClaim) (int, string) {
+	token, err := NewJWTToken(validationKey, jwt.SigningMethodHS256, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	body := make(map[string]string)
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body["code"]
+}
+
+func roleRouter() *gin.Engine {
+	conf := &config.YamlConfig{JWTToken: config.JWTTokenSection{Enabled: true}}
+	r := gin.New()
+	r.Use(JwtClaimsMiddleware(conf, func() jwt.Claims { return &jwtmodels.UserClaim{} }, jwt.SigningMethodHS256, validationKeyFunc))
+	return r
+}
+
+func TestRequireRoles(t *testing.T) {
+	r := roleRouter()
+	ok := func(c *gin.Context) { c.String(200, "ok") }
+	r.GET("/all", RequireRoles(nil, "admin", "operator"), ok)
+	r.GET("/any", RequireAnyRole(nil, "admin", "operator"), ok)
+
+	operator := jwtmodels.UserClaim{ID: "user-1", Roles: []string{"operator"}, Enabled: true}
+	both := jwtmodels.UserClaim{ID: "user-2", Roles: []string{"admin", "operator"}, Enabled: true}
+	disabled := jwtmodels.UserClaim{ID: "user-3", Roles: []string{"admin", "operator"}}
+
+	code, errCode := roleRequest(t, r, "GET", "/all", operator)
+	assert.Equal(t, 403, code)
+	assert.Equal(t, "forbidden", errCode)
+	code, _ = roleRequest(t, r, "GET", "/all", both)
+	assert.Equal(t, 200, code)
+	code, _ = roleRequest(t, r, "GET", "/any", operator)
+	assert.Equal(t, 200, code)
+	code, errCode = roleRequest(t, r, "GET", "/any", disabled)
+	assert.Equal(t, 403, code)
+	assert.Equal(t, "user_disabled", errCode)
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(file, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := roleRouter()
+	r.Use(PolicyMiddleware(policy, nil))
+	ok := func(c *gin.Context) { c.String(200, "ok") }
+	r.GET("/admin/users", ok)
+	r.GET("/devices/camera-1", ok)
+	r.DELETE("/devices/camera-1", ok)
+	r.GET("/other", ok)
+
+	viewer := jwtmodels.UserClaim{ID: "viewer", Roles: []string{"viewer"}, Enabled: true}
+	operator := jwtmodels.UserClaim{ID: "operator", Roles: []string{"operator"}, Enabled: true}
+	admin := jwtmodels.UserClaim{ID: "admin", Roles: []string{"admin"}, Enabled: true}
+
+	tests := []struct {
+		method, path string
+		claims       jwtmodels.UserClaim
+		code         int
+	}{
+		{"GET", "/admin/users", operator, 403},
+		{"GET", "/admin/users", admin, 200},
+		{"GET", "/devices/camera-1", viewer, 200},
+		{"DELETE", "/devices/camera-1", viewer, 403},
+		{"DELETE", "/devices/camera-1", operator, 200},
+		{"GET", "/other", admin, 403}, // default deny
+	}
+	for _, tt := range tests {
+		code, _ := roleRequest(t, r, tt.method, tt.path, tt.claims)
+		if code != tt.code {
+			t.Fatalf("%v %v as %v: expected %v, got %v", tt.method, tt.path, tt.claims.ID, tt.code, code)
+		}
+	}
+
+	if _, err := NewPolicy(Policy{Rules: []PolicyRule{{Path: "("}}}); err == nil {
+		t.Fatal("expected invalid pattern error")
+	}
+}
@@ -15,31 +15,218 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"net/http"
 	"regexp"
+	"sync"
+	"time"
 
 	"github.com/chryscloud/go-microkit-plugins/config"
+	"github.com/chryscloud/go-microkit-plugins/crypto"
 	"github.com/gin-gonic/gin"
 )
 
-// TokenMiddleware simple token authorization
+const (
+	// APITokenNameContextKey holds the name of the authenticated API token in the context (for auditing)
+	APITokenNameContextKey string = "APITokenName"
+)
+
+var (
+	// ErrAPITokenInvalid unknown or missing API token
+	ErrAPITokenInvalid = &TokenError{Status: http.StatusForbidden, Code: "api_token_invalid", Message: "Authorization failed"}
+	// ErrAPITokenExpired API token past its expiry date
+	ErrAPITokenExpired = &TokenError{Status: http.StatusForbidden, Code: "api_token_expired", Message: "API token expired"}
+	// ErrAPITokenScope API token not allowed for the path or method
+	ErrAPITokenScope = &TokenError{Status: http.StatusForbidden, Code: "api_token_scope", Message: "API token not allowed for this request"}
+)
+
+// apiToken compiled config.APITokenSection
+type apiToken struct {
+	name    string
+	hash    []byte
+	paths   []*regexp.Regexp
+	methods []string
+	expires time.Time
+}
+
+// HashAPIToken hex HMAC-SHA256 of token with hashKey, the value stored in auth_token tokens hash
+func HashAPIToken(token, hashKey string) string {
+	return crypto.ComputeHmac(sha256.New, token, hashKey)
+}
+
+// TokenMiddleware simple token authorization. With auth_token tokens every request needs one of the named tokens
+// allowed for its path and method, otherwise the single auth_token token. Tokens are compared in constant time.
+// auth_token is read on every request, so changes at runtime take effect. Panics on invalid path pattern or token hash,
+// so a configuration typo never disables authorization (invalid configuration set at runtime fails requests with 500)
 func TokenMiddleware(conf *config.YamlConfig) gin.HandlerFunc {
+	compiled := &apiTokenConfig{}
+	if _, _, err := compiled.compile(conf.AuthToken); err != nil && conf.AuthToken.Enabled {
+		panic(fmt.Sprintf("invalid auth_token configuration: %v", err))
+	}
 	return func(c *gin.Context) {
-		if conf.AuthToken.Enabled {
+		section := conf.AuthToken
+		if section.Enabled {
+			checkPath, tokens, err := compiled.compile(section)
+			if err != nil {
+				abortWithTokenError(c, err)
+				return
+			}
 			shouldCheck := true
-			if conf.AuthToken.Path != "" {
-				matched, _ := regexp.MatchString(conf.AuthToken.Path, c.Request.RequestURI)
-				shouldCheck = matched
+			if checkPath != nil {
+				shouldCheck = checkPath.MatchString(c.Request.RequestURI)
 			}
 			if shouldCheck {
-				reqToken := c.GetHeader(conf.AuthToken.Header)
-				if reqToken != conf.AuthToken.Token {
-					c.JSON(http.StatusForbidden, gin.H{"error": "Authorization failed"})
-					c.Abort()
+				reqToken := c.GetHeader(section.Header)
+				if len(section.Tokens) == 0 {
+					// compare digests, so the time doesn't depend on the token length either
+					expected, received := sha256.Sum256([]byte(section.Token)), sha256.Sum256([]byte(reqToken))
+					if !hmac.Equal(expected[:], received[:]) {
+						abortWithTokenError(c, ErrAPITokenInvalid)
+						return
+					}
+					c.Next()
 					return
 				}
+				token, err := authenticateAPIToken(tokens, reqToken, section.HashKey, c.Request.Method, c.Request.URL.Path)
+				if err != nil {
+					abortWithTokenError(c, err)
+					return
+				}
+				c.Set(APITokenNameContextKey, token.name)
 			}
 		}
 		c.Next()
 	}
 }
+
+// apiTokenConfig compiled auth_token path and tokens, compiled again when they change
+type apiTokenConfig struct {
+	mutex     sync.Mutex
+	compiled  bool
+	path      string
+	sections  []config.APITokenSection // copy of the compiled sections
+	checkPath *regexp.Regexp
+	tokens    []*apiToken
+	err       error
+}
+
+// compile returns compiled path pattern (nil if not set) and tokens of the section
+func (tc *apiTokenConfig) compile(section config.AuthTokenSection) (*regexp.Regexp, []*apiToken, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	if tc.compiled && tc.path == section.Path && equalAPITokenSections(tc.sections, section.Tokens) {
+		return tc.checkPath, tc.tokens, tc.err
+	}
+	tc.compiled = true
+	tc.path = section.Path
+	tc.sections = copyAPITokenSections(section.Tokens)
+	tc.checkPath = nil
+	tc.tokens, tc.err = compileAPITokens(tc.sections)
+	if tc.err == nil && section.Path != "" {
+		tc.checkPath, tc.err = regexp.Compile(section.Path)
+	}
+	return tc.checkPath, tc.tokens, tc.err
+}
+
+// copyAPITokenSections deep copy, so changes of the configuration in place are detected
+func copyAPITokenSections(sections []config.APITokenSection) []config.APITokenSection {
+	copied := make([]config.APITokenSection, len(sections))
+	for i, section := range sections {
+		section.Paths = append([]string(nil), section.Paths...)
+		section.Methods = append([]string(nil), section.Methods...)
+		copied[i] = section
+	}
+	return copied
+}
+
+func equalAPITokenSections(a, b []config.APITokenSection) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Hash != b[i].Hash || !a[i].Expires.Equal(b[i].Expires) ||
+			!equalStrings(a[i].Paths, b[i].Paths) || !equalStrings(a[i].Methods, b[i].Methods) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetAPITokenName name of the API token that authenticated the request
+func GetAPITokenName(c *gin.Context) (string, bool) {
+	name, ok := c.Get(APITokenNameContextKey)
+	if !ok {
+		return "", false
+	}
+	s, ok := name.(string)
+	return s, ok
+}
+
+// compileAPITokens decodes hashes and compiles path patterns
+func compileAPITokens(sections []config.APITokenSection) ([]*apiToken, error) {
+	tokens := make([]*apiToken, 0, len(sections))
+	for _, section := range sections {
+		hash, err := hex.DecodeString(section.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("token %q: hash must be hex encoded HMAC-SHA256", section.Name)
+		}
+		token := &apiToken{name: section.Name, hash: hash, methods: section.Methods, expires: section.Expires}
+		for _, path := range section.Paths {
+			pattern, err := regexp.Compile("^(?:" + path + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("token %q: %v", section.Name, err)
+			}
+			token.paths = append(token.paths, pattern)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// authenticateAPIToken finds the token by its hash and checks expiry and scope
+func authenticateAPIToken(tokens []*apiToken, reqToken, hashKey, method, path string) (*apiToken, error) {
+	if reqToken == "" {
+		return nil, ErrAPITokenInvalid
+	}
+	hash, _ := hex.DecodeString(HashAPIToken(reqToken, hashKey))
+	var found *apiToken
+	// compare with all tokens, the time doesn't depend on which one matches
+	for _, token := range tokens {
+		if hmac.Equal(hash, token.hash) && found == nil {
+			found = token
+		}
+	}
+	if found == nil {
+		return nil, ErrAPITokenInvalid
+	}
+	if !found.expires.IsZero() && time.Now().After(found.expires) {
+		return nil, ErrAPITokenExpired
+	}
+	if len(found.methods) > 0 && !containsFold(found.methods, method) {
+		return nil, ErrAPITokenScope
+	}
+	if len(found.paths) == 0 {
+		return found, nil
+	}
+	for _, pattern := range found.paths {
+		if pattern.MatchString(path) {
+			return found, nil
+		}
+	}
+	return nil, ErrAPITokenScope
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/config"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func apiTokenRouter(conf *config.YamlConfig) *gin.Engine {
+	r := gin.New()
+	r.Use(TokenMiddleware(conf))
+	handler := func(c *gin.Context) {
+		name, _ := GetAPITokenName(c)
+		c.String(200, name)
+	}
+	r.GET("/api/v1/devices", handler)
+	r.POST("/api/v1/deploy/app", handler)
+	r.GET("/public", handler)
+	return r
+}
+
+func apiTokenRequest(r *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("X-API-Key", token)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenMiddleware(t *testing.T) {
+	conf := &config.YamlConfig{AuthToken: config.AuthTokenSection{Enabled: true, Header: "X-API-Key", Token: "secret_token", Path: "/api/.*"}}
+	r := apiTokenRouter(conf)
+
+	assert.Equal(t, 200, apiTokenRequest(r, "GET", "/api/v1/devices", "secret_token").Code)
+	assert.Equal(t, 403, apiTokenRequest(r, "GET", "/api/v1/devices", "secret").Code)
+	assert.Equal(t, 403, apiTokenRequest(r, "GET", "/api/v1/devices", "").Code)
+	assert.Equal(t, 200, apiTokenRequest(r, "GET", "/public", "").Code)
+}
+
+func TestNamedAPITokens(t *testing.T) {
+	hashKey := "hash key"
+	conf := &config.YamlConfig{AuthToken: config.AuthTokenSection{
+		Enabled: true,
+		Header:  "X-API-Key",
+		HashKey: hashKey,
+		Path:    "/api/.*",
+		Tokens: []config.APITokenSection{
+			{Name: "ci", Hash: HashAPIToken("ci-token", hashKey), Paths: []string{"/api/v1/deploy/.*"}, Methods: []string{"POST"}},
+			{Name: "monitoring", Hash: HashAPIToken("monitoring-token", hashKey), Methods: []string{"GET"}},
+			{Name: "retired", Hash: HashAPIToken("retired-token", hashKey), Expires: time.Now().Add(-time.Hour)},
+		},
+	}}
+	r := apiTokenRouter(conf)
+
+	tests := []struct {
+		method, path, token string
+		code                int
+		name                string
+	}{
+		{"POST", "/api/v1/deploy/app", "ci-token", 200, "ci"},
+		{"GET", "/api/v1/devices", "ci-token", 403, ""},
+		{"GET", "/api/v1/devices", "monitoring-token", 200, "monitoring"},
+		{"POST", "/api/v1/deploy/app", "monitoring-token", 403, ""},
+		{"GET", "/api/v1/devices", "retired-token", 403, ""},
+		{"GET", "/api/v1/devices", "", 403, ""},
+		{"GET", "/api/v1/devices", HashAPIToken("monitoring-token", hashKey), 403, ""}, // the hash itself is not a token
+		{"GET", "/public", "", 200, ""},
+	}
+	for _, tt := range tests {
+		w := apiTokenRequest(r, tt.method, tt.path, tt.token)
+		if w.Code != tt.code || (tt.code == 200 && w.Body.String() != tt.name) {
+			t.Fatalf("%v %v with %v: expected %v %v, got %v %v", tt.method, tt.path, tt.token, tt.code, tt.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestInvalidAPITokensFailClosed(t *testing.T) {
+	hashKey := "hash key"
+	tests := []config.AuthTokenSection{
+		{Tokens: []config.APITokenSection{{Name: "typo", Hash: "not-hex-typo"}}},
+		{Tokens: []config.APITokenSection{{Name: "short", Hash: "abcd"}}},
+		{Tokens: []config.APITokenSection{{Name: "invalid scope", Hash: HashAPIToken("scope-token", hashKey), Paths: []string{"("}}}},
+		{Path: "(", Token: "secret_token"},
+	}
+	for _, section := range tests {
+		section.Enabled = true
+		section.Header = "X-API-Key"
+		section.HashKey = hashKey
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for invalid configuration %+v", section)
+				}
+			}()
+			TokenMiddleware(&config.YamlConfig{AuthToken: section})
+		}()
+	}
+}
+
+func TestTokenMiddlewareRuntimeChanges(t *testing.T) {
+	conf := &config.YamlConfig{AuthToken: config.AuthTokenSection{Header: "X-API-Key", Token: "secret_token", Path: "/api/.*"}}
+	r := apiTokenRouter(conf)
+	assert.Equal(t, 200, apiTokenRequest(r, "GET", "/api/v1/devices", "").Code)
+
+	conf.AuthToken.Enabled = true
+	assert.Equal(t, 403, apiTokenRequest(r, "GET", "/api/v1/devices", "").Code)
+
+	conf.AuthToken.Token = "rotated_token"
+	assert.Equal(t, 403, apiTokenRequest(r, "GET", "/api/v1/devices", "secret_token").Code)
+	assert.Equal(t, 200, apiTokenRequest(r, "GET", "/api/v1/devices", "rotated_token").Code)
+
+	conf.AuthToken.Path = "/public"
+	assert.Equal(t, 200, apiTokenRequest(r, "GET", "/api/v1/devices", "").Code)
+	assert.Equal(t, 403, apiTokenRequest(r, "GET", "/public", "").Code)
+
+	// named token scope changed in place
+	conf.AuthToken.Path = ""
+	conf.AuthToken.HashKey = "hash key"
+	conf.AuthToken.Tokens = []config.APITokenSection{{Name: "deploy", Hash: HashAPIToken("deploy-token", "hash key"), Paths: []string{"/api/v1/deploy/.*"}}}
+	assert.Equal(t, 403, apiTokenRequest(r, "GET", "/api/v1/devices", "deploy-token").Code)
+	conf.AuthToken.Tokens[0].Paths[0] = "/api/v1/.*"
+	assert.Equal(t, 200, apiTokenRequest(r, "GET", "/api/v1/devices", "deploy-token").Code)
+
+	// invalid configuration at runtime fails closed
+	conf.AuthToken.Tokens[0].Hash = "not-hex"
+	assert.Equal(t, 500, apiTokenRequest(r, "GET", "/api/v1/devices", "deploy-token").Code)
+}
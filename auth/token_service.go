@@ -0,0 +1,355 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/config"
+	"github.com/chryscloud/go-microkit-plugins/crypto"
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenPair - access and refresh token issued by TokenService
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // access token lifetime in seconds
+}
+
+// ClaimsLoader returns current custom claims of the subject on refresh (e.g. roles from database).
+// Returning an error (e.g. ErrUserDisabled) rejects the refresh and keeps the refresh token valid.
+// Errors other than TokenError are responded as 500 by RefreshHandler
+type ClaimsLoader func(subject string) (map[string]interface{}, error)
+
+// TokenServiceOptions settings of the token service
+type TokenServiceOptions struct {
+	Log          mclog.Logger
+	AccessTTL    time.Duration
+	RefreshTTL   time.Duration
+	Issuer       string
+	Audience     []string
+	KeyID        string
+	Store        RevocationStore
+	ClaimsLoader ClaimsLoader
+	Validation   TokenValidation // applied to refresh and logout tokens
+}
+
+// TokenServiceOption a single token service option
+type TokenServiceOption func(*TokenServiceOptions)
+
+// TokenLog - logs refresh token reuse and revocations
+func TokenLog(log mclog.Logger) TokenServiceOption {
+	return func(args *TokenServiceOptions) {
+		args.Log = log
+	}
+}
+
+// TokenTTL - lifetime of access (default 15 minutes) and refresh tokens (default 30 days)
+func TokenTTL(access, refresh time.Duration) TokenServiceOption {
+	return func(args *TokenServiceOptions) {
+		args.AccessTTL = access
+		args.RefreshTTL = refresh
+	}
+}
+
+// TokenIssuer - iss and aud of issued tokens, also required from refresh tokens. With more than one audience aud is
+// a JSON array, which jwt.StandardClaims based claims (e.g. models/jwt.UserClaim) can't parse: use jwt.MapClaims in Middleware
+func TokenIssuer(issuer string, audience ...string) TokenServiceOption {
+	return func(args *TokenServiceOptions) {
+		args.Issuer = issuer
+		args.Audience = audience
+	}
+}
+
+// TokenKeyID - kid header of issued tokens (e.g. to verify them with JWKS)
+func TokenKeyID(kid string) TokenServiceOption {
+	return func(args *TokenServiceOptions) {
+		args.KeyID = kid
+	}
+}
+
+// TokenStore - revocation store, in-memory by default. Use a shared store with multiple instances
+func TokenStore(store RevocationStore) TokenServiceOption {
+	return func(args *TokenServiceOptions) {
+		args.Store = store
+	}
+}
+
+// TokenClaimsLoader - reload custom claims on refresh instead of copying them from the refresh token
+func TokenClaimsLoader(loader ClaimsLoader) TokenServiceOption {
+	return func(args *TokenServiceOptions) {
+		args.ClaimsLoader = loader
+	}
+}
+
+// TokenService - issues access and refresh token pairs, rotates refresh tokens and revokes token families.
+// Tokens from one Issue (and all of its refreshes) share a family (fam claim). Reusing a rotated refresh token revokes the family
+type TokenService struct {
+	options TokenServiceOptions
+	method  jwt.SigningMethod
+	signKey interface{}
+	keyFunc jwt.Keyfunc
+}
+
+// NewTokenService signs tokens with signKey and verifies them with keyFunc (for HMAC both return the same secret)
+func NewTokenService(method jwt.SigningMethod, signKey interface{}, keyFunc jwt.Keyfunc, opts ...TokenServiceOption) *TokenService {
+	args := TokenServiceOptions{
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+	}
+	for _, op := range opts {
+		op(&args)
+	}
+	if args.Store == nil {
+		args.Store = NewMemoryRevocationStore()
+	}
+	if args.Issuer != "" {
+		args.Validation.Issuers = []string{args.Issuer}
+	}
+	args.Validation.Audiences = args.Audience
+	args.Validation.Revocations = args.Store
+	return &TokenService{
+		options: args,
+		method:  method,
+		signKey: signKey,
+		keyFunc: keyFunc,
+	}
+}
+
+// Store revocation store, pass it to JwtRevocations so the middleware rejects revoked access tokens
+func (s *TokenService) Store() RevocationStore {
+	return s.options.Store
+}
+
+// Middleware JwtClaimsMiddleware accepting access tokens of this service and rejecting revoked ones
+func (s *TokenService) Middleware(conf *config.YamlConfig, newClaims func() jwt.Claims, opts ...JwtOption) gin.HandlerFunc {
+	defaults := []JwtOption{JwtRevocations(s.options.Store), JwtAudiences(s.options.Audience...)}
+	if s.options.Issuer != "" {
+		defaults = append(defaults, JwtIssuers(s.options.Issuer))
+	}
+	return JwtClaimsMiddleware(conf, newClaims, s.method, s.keyFunc, append(defaults, opts...)...)
+}
+
+// Issue new token pair (a new family) for subject with custom claims (e.g. id, Roles, enabled of models/jwt.UserClaim)
+func (s *TokenService) Issue(subject string, claims map[string]interface{}) (*TokenPair, error) {
+	family, err := crypto.GenerateSecretKey(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(subject, family, claims)
+}
+
+// Refresh rotates refresh token: returns a new pair and revokes the used refresh token.
+// A refresh token used twice revokes its whole family (ErrRefreshTokenReused)
+func (s *TokenService) Refresh(refreshToken string) (*TokenPair, error) {
+	validation := s.options.Validation
+	validation.refresh = true
+	// used refresh tokens are detected below
+	validation.Revocations = nil
+	token, err := ParseToken(refreshToken, jwt.MapClaims{}, s.method, s.keyFunc, validation)
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	subject, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	family, _ := claims["fam"].(string)
+	if jti == "" || family == "" {
+		return nil, ErrTokenInvalid
+	}
+
+	revoked, err := s.options.Store.IsRevoked(family)
+	if err != nil {
+		return nil, ErrRevocationStore
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	// load claims before the refresh token is used up, so the client can retry after a temporary loader failure
+	custom := customClaimsOf(claims)
+	if s.options.ClaimsLoader != nil {
+		if custom, err = s.options.ClaimsLoader(subject); err != nil {
+			if s.options.Log != nil {
+				s.options.Log.Error("failed to load claims on refresh", "subject", subject, err)
+			}
+			return nil, err
+		}
+	}
+
+	first, err := s.options.Store.Revoke(jti, s.expiry(claims))
+	if err != nil {
+		return nil, ErrRevocationStore
+	}
+	if !first {
+		if s.options.Log != nil {
+			s.options.Log.Warn("refresh token reuse detected, revoking token family", "subject", subject, "family", family)
+		}
+		if _, err := s.options.Store.Revoke(family, time.Now().Add(s.options.RefreshTTL)); err != nil {
+			return nil, ErrRevocationStore
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	return s.issue(subject, family, custom)
+}
+
+// Revoke token family of the (access or refresh) token, e.g. on logout
+func (s *TokenService) Revoke(tokenString string) error {
+	// typ is verified by ParseToken
+	unverified, err := registeredClaims(tokenString)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	validation := s.options.Validation
+	validation.refresh = unverified["typ"] == refreshTokenType
+	token, err := ParseToken(tokenString, jwt.MapClaims{}, s.method, s.keyFunc, validation)
+	if err != nil {
+		return err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	family, _ := claims["fam"].(string)
+	if family == "" {
+		return ErrTokenInvalid
+	}
+	if _, err := s.options.Store.Revoke(family, time.Now().Add(s.options.RefreshTTL)); err != nil {
+		return ErrRevocationStore
+	}
+	if s.options.Log != nil {
+		subject, _ := claims["sub"].(string)
+		s.options.Log.Info("token family revoked", "subject", subject, "family", family)
+	}
+	return nil
+}
+
+// RefreshHandler gin handler for POST /token/refresh with {"refresh_token": "..."} JSON or form body.
+// Responds with a new TokenPair
+func (s *TokenService) RefreshHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			RefreshToken string `json:"refresh_token" form:"refresh_token"`
+		}
+		if err := c.ShouldBind(&request); err != nil || request.RefreshToken == "" {
+			abortWithTokenError(c, ErrTokenMissing)
+			return
+		}
+		pair, err := s.Refresh(request.RefreshToken)
+		if err != nil {
+			abortWithTokenError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, pair)
+	}
+}
+
+// LogoutHandler gin handler revoking token family of the Authorization bearer token (or refresh_token in the body).
+// Responds with 204 No Content
+func (s *TokenService) LogoutHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := FromAuthorizationHeader()(c)
+		if tokenString == "" {
+			var request struct {
+				RefreshToken string `json:"refresh_token" form:"refresh_token"`
+			}
+			c.ShouldBind(&request)
+			tokenString = request.RefreshToken
+		}
+		if tokenString == "" {
+			abortWithTokenError(c, ErrTokenMissing)
+			return
+		}
+		if err := s.Revoke(tokenString); err != nil {
+			abortWithTokenError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// issue signed access and refresh token of family
+func (s *TokenService) issue(subject, family string, custom map[string]interface{}) (*TokenPair, error) {
+	now := time.Now()
+	access, err := s.sign(subject, family, accessTokenType, custom, now, s.options.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := s.sign(subject, family, refreshTokenType, custom, now, s.options.RefreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.options.AccessTTL / time.Second),
+	}, nil
+}
+
+func (s *TokenService) sign(subject, family, typ string, custom map[string]interface{}, now time.Time, ttl time.Duration) (string, error) {
+	jti, err := crypto.GenerateSecretKey(16)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{}
+	for k, v := range custom {
+		claims[k] = v
+	}
+	claims["sub"] = subject
+	claims["jti"] = jti
+	claims["fam"] = family
+	claims["typ"] = typ
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	if s.options.Issuer != "" {
+		claims["iss"] = s.options.Issuer
+	}
+	switch len(s.options.Audience) {
+	case 0:
+	case 1:
+		// single audience as string, jwt.StandardClaims can't parse arrays
+		claims["aud"] = s.options.Audience[0]
+	default:
+		claims["aud"] = s.options.Audience
+	}
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.options.KeyID != "" {
+		token.Header["kid"] = s.options.KeyID
+	}
+	return token.SignedString(s.signKey)
+}
+
+// expiry of the token from exp claim
+func (s *TokenService) expiry(claims jwt.MapClaims) time.Time {
+	if exp, ok := numericDate(claims["exp"]); ok {
+		return exp
+	}
+	return time.Now().Add(s.options.RefreshTTL)
+}
+
+// customClaimsOf claims of the refresh token that are not set by TokenService
+func customClaimsOf(claims jwt.MapClaims) map[string]interface{} {
+	custom := make(map[string]interface{})
+	for k, v := range claims {
+		switch k {
+		case "sub", "jti", "fam", "typ", "iat", "exp", "nbf", "iss", "aud":
+		default:
+			custom[k] = v
+		}
+	}
+	return custom
+}
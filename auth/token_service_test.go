@@ -0,0 +1,239 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/config"
+	jwtmodels "github.com/chryscloud/go-microkit-plugins/models/jwt"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func newTestTokenService(opts ...TokenServiceOption) *TokenService {
+	return NewTokenService(jwt.SigningMethodHS256, validationKey, validationKeyFunc,
+		append([]TokenServiceOption{TokenIssuer("https://auth.chryscloud.com", "edge-api")}, opts...)...)
+}
+
+func tokenRouter(s *TokenService) *gin.Engine {
+	conf := &config.YamlConfig{JWTToken: config.JWTTokenSection{Enabled: true}}
+	r := gin.New()
+	r.POST("/token/refresh", s.RefreshHandler())
+	r.POST("/logout", s.LogoutHandler())
+	r.GET("/me", s.Middleware(conf, func() jwt.Claims { return &jwtmodels.UserClaim{} }), func(c *gin.Context) {
+		claims, err := GetClaims[*jwtmodels.UserClaim](c)
+		if err != nil {
+			c.String(404, err.Error())
+			return
+		}
+		c.String(200, claims.ID)
+	})
+	return r
+}
+
+func bearerRequest(r *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenServiceRotation(t *testing.T) {
+	s := newTestTokenService()
+	r := tokenRouter(s)
+
+	pair, err := s.Issue("user-1", map[string]interface{}{"id": "user-1", "Roles": []string{"admin"}, "enabled": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bearerRequest(r, "GET", "/me", pair.AccessToken, nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "user-1", w.Body.String())
+	// refresh token is not an access token
+	w = bearerRequest(r, "GET", "/me", pair.RefreshToken, nil)
+	assert.Equal(t, 400, w.Code)
+
+	w = bearerRequest(r, "POST", "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, 200, w.Code)
+	var rotated TokenPair
+	if err := json.Unmarshal(w.Body.Bytes(), &rotated); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Bearer", rotated.TokenType)
+	w = bearerRequest(r, "GET", "/me", rotated.AccessToken, nil)
+	assert.Equal(t, "user-1", w.Body.String())
+
+	// reuse of the rotated refresh token revokes the family
+	if _, err := s.Refresh(pair.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected reuse detection, got %v", err)
+	}
+	if _, err := s.Refresh(rotated.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected revoked family, got %v", err)
+	}
+	w = bearerRequest(r, "GET", "/me", rotated.AccessToken, nil)
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestTokenServiceConcurrentRefresh(t *testing.T) {
+	s := newTestTokenService()
+	pair, _ := s.Issue("user-1", nil)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Refresh(pair.RefreshToken); err == nil {
+				mutex.Lock()
+				succeeded++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one successful refresh, got %v", succeeded)
+	}
+}
+
+func TestTokenServiceLogout(t *testing.T) {
+	s := newTestTokenService(TokenTTL(time.Minute, time.Hour))
+	r := tokenRouter(s)
+	pair, _ := s.Issue("user-1", map[string]interface{}{"id": "user-1", "enabled": true})
+
+	w := bearerRequest(r, "POST", "/logout", pair.AccessToken, nil)
+	assert.Equal(t, 204, w.Code)
+
+	w = bearerRequest(r, "GET", "/me", pair.AccessToken, nil)
+	assert.Equal(t, 401, w.Code)
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, "token_revoked", body["code"])
+
+	if _, err := s.Refresh(pair.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected revoked refresh token, got %v", err)
+	}
+
+	// logout with refresh token in body
+	pair, _ = s.Issue("user-1", nil)
+	w = bearerRequest(r, "POST", "/logout", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, 204, w.Code)
+	if _, err := s.Refresh(pair.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected revoked refresh token, got %v", err)
+	}
+}
+
+func TestTokenServiceClaimsLoader(t *testing.T) {
+	enabled := true
+	s := newTestTokenService(TokenClaimsLoader(func(subject string) (map[string]interface{}, error) {
+		if !enabled {
+			return nil, ErrUserDisabled
+		}
+		return map[string]interface{}{"id": subject, "Roles": []string{"operator"}, "enabled": true}, nil
+	}))
+	pair, _ := s.Issue("user-1", map[string]interface{}{"id": "user-1", "Roles": []string{"viewer"}, "enabled": true})
+
+	rotated, err := s.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &jwtmodels.UserClaim{}
+	if _, err := ParseToken(rotated.AccessToken, claims, jwt.SigningMethodHS256, validationKeyFunc, TokenValidation{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "operator" {
+		t.Fatalf("expected reloaded roles, got %v", claims.Roles)
+	}
+
+	enabled = false
+	if _, err := s.Refresh(rotated.RefreshToken); err != ErrUserDisabled {
+		t.Fatalf("expected disabled user, got %v", err)
+	}
+}
+
+func TestTokenServiceClaimsLoaderFailure(t *testing.T) {
+	errDatabase := errors.New("database unavailable")
+	failing := true
+	s := newTestTokenService(TokenClaimsLoader(func(subject string) (map[string]interface{}, error) {
+		if failing {
+			return nil, errDatabase
+		}
+		return map[string]interface{}{"id": subject, "enabled": true}, nil
+	}))
+	r := tokenRouter(s)
+	pair, _ := s.Issue("user-1", nil)
+
+	w := bearerRequest(r, "POST", "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, 500, w.Code)
+
+	// retry with the same refresh token once the loader recovers
+	failing = false
+	w = bearerRequest(r, "POST", "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestTokenServiceMultipleAudiences(t *testing.T) {
+	s := NewTokenService(jwt.SigningMethodHS256, validationKey, validationKeyFunc, TokenIssuer("https://auth.chryscloud.com", "edge-api", "admin-api"))
+	conf := &config.YamlConfig{JWTToken: config.JWTTokenSection{Enabled: true}}
+	r := gin.New()
+	r.POST("/token/refresh", s.RefreshHandler())
+	r.GET("/map", s.Middleware(conf, func() jwt.Claims { return jwt.MapClaims{} }), func(c *gin.Context) {
+		claims, err := GetClaims[jwt.MapClaims](c)
+		if err != nil {
+			c.String(404, err.Error())
+			return
+		}
+		c.String(200, claims["sub"].(string))
+	})
+	r.GET("/standard", s.Middleware(conf, func() jwt.Claims { return &jwtmodels.UserClaim{} }), func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+
+	pair, _ := s.Issue("user-1", nil)
+	w := bearerRequest(r, "GET", "/map", pair.AccessToken, nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "user-1", w.Body.String())
+	// aud array doesn't fit jwt.StandardClaims
+	w = bearerRequest(r, "GET", "/standard", pair.AccessToken, nil)
+	assert.Equal(t, 400, w.Code)
+
+	w = bearerRequest(r, "POST", "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, 200, w.Code)
+	var rotated TokenPair
+	if err := json.Unmarshal(w.Body.Bytes(), &rotated); err != nil {
+		t.Fatal(err)
+	}
+	w = bearerRequest(r, "GET", "/map", rotated.AccessToken, nil)
+	assert.Equal(t, 200, w.Code)
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenError - typed token validation error, responded as {"error": Message, "code": Code} with Status
+type TokenError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *TokenError) Error() string {
+	return e.Message
+}
+
+// Is matches errors by Code, e.g. errors.Is(err, ErrTokenExpired)
+func (e *TokenError) Is(target error) bool {
+	t, ok := target.(*TokenError)
+	return ok && t.Code == e.Code
+}
+
+var (
+	// ErrTokenMissing no token in the request
+	ErrTokenMissing = &TokenError{Status: http.StatusUnauthorized, Code: "token_missing", Message: "Authorization failed"}
+	// ErrTokenMalformed token can't be decoded
+	ErrTokenMalformed = &TokenError{Status: http.StatusBadRequest, Code: "token_malformed", Message: "JWT Token is malformed"}
+	// ErrTokenSignature signature doesn't match or signing key isn't available
+	ErrTokenSignature = &TokenError{Status: http.StatusUnauthorized, Code: "invalid_signature", Message: "invalid token signature"}
+	// ErrTokenExpired exp is in the past (beyond leeway)
+	ErrTokenExpired = &TokenError{Status: http.StatusUnauthorized, Code: "token_expired", Message: "JWT Token is expired"}
+	// ErrTokenNotValidYet nbf or iat is in the future (beyond leeway)
+	ErrTokenNotValidYet = &TokenError{Status: http.StatusUnauthorized, Code: "token_not_valid_yet", Message: "token is not valid yet"}
+	// ErrTokenTooOld token was issued longer than max age ago
+	ErrTokenTooOld = &TokenError{Status: http.StatusUnauthorized, Code: "token_too_old", Message: "token is too old"}
+	// ErrTokenIssuer iss is not one of the accepted issuers
+	ErrTokenIssuer = &TokenError{Status: http.StatusUnauthorized, Code: "invalid_issuer", Message: "token issuer not accepted"}
+	// ErrTokenAudience aud doesn't contain any of the accepted audiences
+	ErrTokenAudience = &TokenError{Status: http.StatusUnauthorized, Code: "invalid_audience", Message: "token audience not accepted"}
+	// ErrTokenMissingClaim required claim is missing
+	ErrTokenMissingClaim = &TokenError{Status: http.StatusUnauthorized, Code: "missing_claim", Message: "required claim missing"}
+	// ErrTokenInvalid claims failed custom validation
+	ErrTokenInvalid = &TokenError{Status: http.StatusBadRequest, Code: "invalid_token", Message: "invalid token"}
+	// ErrTokenRevoked token (or its family) was revoked, e.g. on logout
+	ErrTokenRevoked = &TokenError{Status: http.StatusUnauthorized, Code: "token_revoked", Message: "token has been revoked"}
+	// ErrRefreshTokenReused already rotated refresh token was used again, the whole token family is revoked
+	ErrRefreshTokenReused = &TokenError{Status: http.StatusUnauthorized, Code: "refresh_token_reused", Message: "refresh token reuse detected"}
+	// ErrRevocationStore revocation store failed
+	ErrRevocationStore = &TokenError{Status: http.StatusServiceUnavailable, Code: "revocation_unavailable", Message: "token revocation check failed"}
+)
+
+const (
+	// maxNumericDate bound of numeric dates (seconds), larger values are clamped to stay within int64 and time.Time range
+	maxNumericDate = 1 << 40
+	// refreshTokenType typ claim of refresh tokens, never accepted as access tokens
+	refreshTokenType = "refresh"
+	accessTokenType  = "access"
+)
+
+// TokenValidation - checks on top of signature verification. Zero values disable the check
+type TokenValidation struct {
+	Issuers        []string        // accepted iss values
+	Audiences      []string        // token aud must contain at least one of them
+	Leeway         time.Duration   // allowed clock skew for exp, nbf and iat
+	MaxAge         time.Duration   // maximum time since iat, requires iat
+	RequiredClaims []string        // claims that must be present (e.g. sub, device_id)
+	Revocations    RevocationStore // rejects revoked jti and token families (fam claim)
+
+	refresh bool // accept only refresh tokens (TokenService)
+}
+
+// ParseToken verifies signature (with method, nil accepts any method keyFunc accepts), parses the token into claims and validates it.
+// Errors are *TokenError
+func ParseToken(tokenString string, claims jwt.Claims, method jwt.SigningMethod, keyFunc jwt.Keyfunc, validation TokenValidation) (*jwt.Token, error) {
+	// time based claims are checked here with leeway
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if method != nil && token.Method.Alg() != method.Alg() {
+			return nil, ErrSigningMethod
+		}
+		return keyFunc(token)
+	})
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if !token.Valid {
+		return nil, ErrTokenInvalid
+	}
+	registered, err := registeredClaims(token.Raw)
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := validation.validate(registered, time.Now()); err != nil {
+		return nil, err
+	}
+	// custom Valid() of claims, time based errors are already checked with leeway
+	if err := claims.Valid(); err != nil {
+		var verr *jwt.ValidationError
+		timeErrors := uint32(jwt.ValidationErrorExpired | jwt.ValidationErrorNotValidYet | jwt.ValidationErrorIssuedAt)
+		if !errors.As(err, &verr) || verr.Errors&^timeErrors != 0 {
+			return nil, &TokenError{Status: ErrTokenInvalid.Status, Code: ErrTokenInvalid.Code, Message: err.Error()}
+		}
+	}
+	return token, nil
+}
+
+// validate registered and required claims at now
+func (v TokenValidation) validate(claims map[string]interface{}, now time.Time) error {
+	for _, name := range v.RequiredClaims {
+		if claims[name] == nil {
+			return &TokenError{Status: ErrTokenMissingClaim.Status, Code: ErrTokenMissingClaim.Code, Message: "required claim " + name + " missing"}
+		}
+	}
+	if exp, ok := numericDate(claims["exp"]); ok && now.After(exp.Add(v.Leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrTokenNotValidYet
+	}
+	iat, hasIat := numericDate(claims["iat"])
+	if hasIat && now.Add(v.Leeway).Before(iat) {
+		return ErrTokenNotValidYet
+	}
+	if v.MaxAge > 0 {
+		if !hasIat {
+			return &TokenError{Status: ErrTokenMissingClaim.Status, Code: ErrTokenMissingClaim.Code, Message: "required claim iat missing"}
+		}
+		if now.Sub(iat) > v.MaxAge+v.Leeway {
+			return ErrTokenTooOld
+		}
+	}
+	if len(v.Issuers) > 0 {
+		iss, _ := claims["iss"].(string)
+		if !contains(v.Issuers, iss) {
+			return ErrTokenIssuer
+		}
+	}
+	if len(v.Audiences) > 0 && !audienceAccepted(claims["aud"], v.Audiences) {
+		return ErrTokenAudience
+	}
+	if typ, _ := claims["typ"].(string); v.refresh != (typ == refreshTokenType) {
+		return ErrTokenInvalid
+	}
+	if v.Revocations != nil {
+		for _, claim := range []string{"jti", "fam"} {
+			id, _ := claims[claim].(string)
+			if id == "" {
+				continue
+			}
+			revoked, err := v.Revocations.IsRevoked(id)
+			if err != nil {
+				return ErrRevocationStore
+			}
+			if revoked {
+				return ErrTokenRevoked
+			}
+		}
+	}
+	return nil
+}
+
+// registeredClaims decodes token payload into a map regardless of claims type
+func registeredClaims(raw string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	payload, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// numericDate JWT NumericDate (seconds since epoch, fractions allowed)
+func numericDate(value interface{}) (time.Time, bool) {
+	var seconds float64
+	switch v := value.(type) {
+	case json.Number:
+		var err error
+		if seconds, err = v.Float64(); err != nil {
+			return time.Time{}, false
+		}
+	case float64:
+		seconds = v
+	default:
+		return time.Time{}, false
+	}
+	if math.IsNaN(seconds) {
+		return time.Time{}, false
+	}
+	// whole seconds and nanoseconds separately, nanoseconds since epoch overflow int64 after 2262
+	seconds = math.Max(math.Min(seconds, maxNumericDate), -maxNumericDate)
+	sec := math.Floor(seconds)
+	return time.Unix(int64(sec), int64((seconds-sec)*1e9)), true
+}
+
+// audienceAccepted aud (string or array of strings) contains one of accepted
+func audienceAccepted(aud interface{}, accepted []string) bool {
+	switch a := aud.(type) {
+	case string:
+		return contains(accepted, a)
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && contains(accepted, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseError TokenError of jwt-go parse error
+func parseError(err error) error {
+	var verr *jwt.ValidationError
+	if !errors.As(err, &verr) {
+		return ErrTokenInvalid
+	}
+	var terr *TokenError
+	switch {
+	case verr.Errors&jwt.ValidationErrorMalformed != 0:
+		return ErrTokenMalformed
+	case errors.As(verr.Inner, &terr):
+		return terr
+	case verr.Inner != nil:
+		return &TokenError{Status: ErrTokenSignature.Status, Code: ErrTokenSignature.Code, Message: verr.Inner.Error()}
+	}
+	return ErrTokenSignature
+}
+
+// abortWithTokenError responds with {"error", "code"} of the TokenError, other errors are server errors
+func abortWithTokenError(c *gin.Context, err error) {
+	var terr *TokenError
+	if !errors.As(err, &terr) {
+		terr = &TokenError{Status: http.StatusInternalServerError, Code: "internal_error", Message: "internal error"}
+	}
+	c.JSON(terr.Status, gin.H{"error": terr.Message, "code": terr.Code})
+	c.Abort()
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/config"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+var validationKey = []byte("my test secret key here")
+
+func validationKeyFunc(token *jwt.Token) (interface{}, error) {
+	return validationKey, nil
+}
+
+// deviceClaims with custom validation
+type deviceClaims struct {
+	DeviceID string `json:"device_id"`
+	jwt.StandardClaims
+}
+
+func (c *deviceClaims) Valid() error {
+	if err := c.StandardClaims.Valid(); err != nil {
+		return err
+	}
+	if c.DeviceID == "blocked" {
+		return errors.New("device is blocked")
+	}
+	return nil
+}
+
+func TestParseTokenValidation(t *testing.T) {
+	now := time.Now()
+	validation := TokenValidation{
+		Issuers:        []string{"https://auth.chryscloud.com"},
+		Audiences:      []string{"edge-api"},
+		Leeway:         time.Minute,
+		MaxAge:         time.Hour,
+		RequiredClaims: []string{"device_id"},
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":       "https://auth.chryscloud.com",
+			"aud":       []string{"other", "edge-api"},
+			"iat":       now.Add(-10 * time.Minute).Unix(),
+			"exp":       now.Add(10 * time.Minute).Unix(),
+			"device_id": "camera-1",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c jwt.MapClaims)
+		err    error
+	}{
+		{"valid", func(c jwt.MapClaims) {}, nil},
+		{"expired within leeway", func(c jwt.MapClaims) { c["exp"] = now.Add(-30 * time.Second).Unix() }, nil},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, ErrTokenExpired},
+		{"not valid yet within leeway", func(c jwt.MapClaims) { c["nbf"] = now.Add(30 * time.Second).Unix() }, nil},
+		{"not valid yet", func(c jwt.MapClaims) { c["nbf"] = now.Add(2 * time.Minute).Unix() }, ErrTokenNotValidYet},
+		{"issued in future", func(c jwt.MapClaims) { c["iat"] = now.Add(2 * time.Minute).Unix() }, ErrTokenNotValidYet},
+		{"too old", func(c jwt.MapClaims) { c["iat"] = now.Add(-2 * time.Hour).Unix() }, ErrTokenTooOld},
+		{"max age without iat", func(c jwt.MapClaims) { delete(c, "iat") }, ErrTokenMissingClaim},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.com" }, ErrTokenIssuer},
+		{"single audience", func(c jwt.MapClaims) { c["aud"] = "edge-api" }, nil},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other" }, ErrTokenAudience},
+		{"missing claim", func(c jwt.MapClaims) { delete(c, "device_id") }, ErrTokenMissingClaim},
+		{"exp after 2262", func(c jwt.MapClaims) { c["exp"] = 9999999999 }, nil},
+		{"exp beyond int64 nanoseconds", func(c jwt.MapClaims) { c["exp"] = 1e300 }, nil},
+		{"fractional exp", func(c jwt.MapClaims) { c["exp"] = float64(now.Unix()) + 600.5 }, nil},
+		{"fractional exp expired", func(c jwt.MapClaims) { c["exp"] = float64(now.Add(-2*time.Minute).Unix()) + 0.5 }, ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+			token, _ := NewJWTToken(validationKey, jwt.SigningMethodHS256, claims)
+			_, err := ParseToken(token, jwt.MapClaims{}, jwt.SigningMethodHS256, validationKeyFunc, validation)
+			if tt.err == nil && err != nil {
+				t.Fatalf("expected valid token, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// custom Valid() still applies, time based errors are checked with leeway instead
+	token, _ := NewJWTToken(validationKey, jwt.SigningMethodHS256, &deviceClaims{DeviceID: "blocked"})
+	if _, err := ParseToken(token, &deviceClaims{}, nil, validationKeyFunc, TokenValidation{}); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected invalid token, got %v", err)
+	}
+	token, _ = NewJWTToken(validationKey, jwt.SigningMethodHS256, &deviceClaims{DeviceID: "camera-1",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: now.Add(-30 * time.Second).Unix()}})
+	if _, err := ParseToken(token, &deviceClaims{}, nil, validationKeyFunc, TokenValidation{Leeway: time.Minute}); err != nil {
+		t.Fatalf("expected valid token within leeway, got %v", err)
+	}
+
+	// signature
+	token, _ = NewJWTToken([]byte("other key"), jwt.SigningMethodHS256, valid())
+	if _, err := ParseToken(token, jwt.MapClaims{}, nil, validationKeyFunc, TokenValidation{}); !errors.Is(err, ErrTokenSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	conf := &config.YamlConfig{
+		JWTToken: config.JWTTokenSection{
+			Enabled:   true,
+			SecretKey: string(validationKey),
+			Issuers:   []string{"https://auth.chryscloud.com"},
+		},
+	}
+	r := gin.New()
+	r.GET("/claims", JwtClaimsMiddleware(conf, func() jwt.Claims { return &jwt.StandardClaims{} }, jwt.SigningMethodHS256, validationKeyFunc,
+		JwtAudiences("edge-api")), func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+
+	request := func(token string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/claims", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		body := make(map[string]string)
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	code, body := request("")
+	assert.Equal(t, 401, code)
+	assert.Equal(t, "token_missing", body["code"])
+
+	token, _ := NewJWTToken(validationKey, jwt.SigningMethodHS256, jwt.StandardClaims{Issuer: "https://auth.chryscloud.com", Audience: "other"})
+	code, body = request(token)
+	assert.Equal(t, 401, code)
+	assert.Equal(t, "invalid_audience", body["code"])
+	assert.Equal(t, "token audience not accepted", body["error"])
+
+	code, body = request("not a token")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "token_malformed", body["code"])
+
+	token, _ = NewJWTToken(validationKey, jwt.SigningMethodHS256, jwt.StandardClaims{Issuer: "https://auth.chryscloud.com", Audience: "edge-api"})
+	code, _ = request(token)
+	assert.Equal(t, 200, code)
+}
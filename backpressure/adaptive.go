@@ -0,0 +1,125 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WorkerCount current number of workers
+func (rc *TypedPressureContext[T]) WorkerCount() int {
+	return int(atomic.LoadInt64(&rc.workerCount))
+}
+
+// BatchSize current maximum batch size (tuned in adaptive mode)
+func (rc *TypedPressureContext[T]) BatchSize() int {
+	return int(atomic.LoadInt64(&rc.batchSize))
+}
+
+func (rc *TypedPressureContext[T]) startWorker(batchChan chan batch[T]) {
+	rc.workerWg.Add(1)
+	atomic.AddInt64(&rc.workerCount, 1)
+	go rc.consumeBatch(batchChan)
+}
+
+// autoscale resizes worker pool until context stops accepting events
+func (rc *TypedPressureContext[T]) autoscale(ticker Ticker) {
+	defer close(rc.scalerDone)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			rc.scale()
+		case <-rc.stopChan:
+			return
+		case <-rc.doneChan:
+			return
+		}
+	}
+}
+
+// scale adds a worker per queued batch while PutMulti keeps up (latency within 2x target),
+// retires half of the idle workers when there are no queued batches
+func (rc *TypedPressureContext[T]) scale() {
+	workers := rc.WorkerCount()
+	active := int(atomic.LoadInt64(&rc.activeWorkers))
+	queued := rc.batchQueueLength()
+	latency := time.Duration(atomic.LoadInt64(&rc.latency))
+
+	switch {
+	case queued > 0 && workers < rc.maxWorkers && latency <= 2*rc.targetLatency:
+		grow := boundInt(queued, 1, rc.maxWorkers-workers)
+		for i := 0; i < grow; i++ {
+			rc.startWorker(rc.batchChans[0])
+		}
+		if rc.log != nil {
+			rc.log.Info("backpressure workers scaled up", workers+grow, "batch queue size", queued, "latency", latency)
+		}
+	case queued == 0 && active < workers && workers > rc.minWorkers:
+		retire := boundInt((workers-active)/2, 1, workers-rc.minWorkers)
+		retired := 0
+		for i := 0; i < retire; i++ {
+			select {
+			case rc.retireChan <- struct{}{}:
+				retired++
+			default:
+			}
+		}
+		if rc.log != nil && retired > 0 {
+			rc.log.Info("backpressure workers scaled down", workers-retired)
+		}
+	}
+}
+
+// tune batch size toward target latency: additive increase after batch delivered within target latency,
+// multiplicative decrease (halving) when latency exceeds target or delivery failed
+func (rc *TypedPressureContext[T]) tune(latency time.Duration, delivered bool) {
+	for {
+		average := atomic.LoadInt64(&rc.latency)
+		next := average + (int64(latency)-average)/5
+		if average == 0 {
+			next = int64(latency)
+		}
+		if atomic.CompareAndSwapInt64(&rc.latency, average, next) {
+			break
+		}
+	}
+
+	for {
+		current := atomic.LoadInt64(&rc.batchSize)
+		next := current
+		if !delivered || latency > rc.targetLatency {
+			next = current / 2
+		} else {
+			next = current + rc.batchStep
+		}
+		next = int64(boundInt(int(next), rc.minBatchSize, rc.batchMaxSize))
+		if next == current || atomic.CompareAndSwapInt64(&rc.batchSize, current, next) {
+			return
+		}
+	}
+}
+
+func boundInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// delayWorker delivers each batch after delay
+type delayWorker struct {
+	delay time.Duration
+}
+
+func (dw *delayWorker) PutMulti(events []interface{}) error {
+	time.Sleep(dw.delay)
+	return nil
+}
+
+func TestAdaptiveBatchSize(t *testing.T) {
+	// fast PutMulti grows batch size up to BatchMaxSize
+	bp, err := NewBackpressureContext(&delayWorker{}, Adaptive(50*time.Millisecond), BatchMaxSize(20), MinBatchSize(2), BatchTimeMs(10000), Workers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp.BatchSize() != 2 || bp.WorkerCount() != 1 {
+		t.Fatalf("expected to start with minimum batch size and workers, got %v, %v", bp.BatchSize(), bp.WorkerCount())
+	}
+	for i := 0; i < 1000; i++ {
+		bp.Add(i)
+	}
+	waitFor(t, func() bool { return bp.BatchSize() == 20 })
+	bp.Shutdown(context.Background())
+
+	// slow PutMulti halves batch size down to MinBatchSize
+	bp, err = NewBackpressureContext(&delayWorker{delay: 5 * time.Millisecond}, Adaptive(time.Millisecond), BatchMaxSize(20), MinBatchSize(2), BatchTimeMs(10000), Workers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&bp.batchSize, 16)
+	for i := 0; i < 16; i++ {
+		bp.Add(i)
+	}
+	waitFor(t, func() bool { return bp.BatchSize() == 8 })
+	for i := 0; i < 64; i++ {
+		bp.Add(i)
+	}
+	waitFor(t, func() bool { return bp.BatchSize() == 2 })
+	bp.Shutdown(context.Background())
+}
+
+func TestAdaptiveWorkers(t *testing.T) {
+	bw := &blockingWorker{release: make(chan struct{})}
+	bp, err := NewBackpressureContext(bw, Adaptive(time.Second), MinWorkers(2), Workers(6), BatchMaxSize(1), MinBatchSize(1), BatchTimeMs(10000), AdaptiveInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp.WorkerCount() != 2 {
+		t.Fatalf("expected 2 workers, got %v", bp.WorkerCount())
+	}
+	for i := 0; i < 20; i++ {
+		bp.Add(i)
+	}
+	waitFor(t, func() bool { return bp.WorkerCount() == 6 })
+
+	close(bw.release)
+	waitFor(t, func() bool { return bp.WorkerCount() == 2 })
+
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+}
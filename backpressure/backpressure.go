@@ -15,17 +15,25 @@
 package backpressure
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mclog "github.com/chryscloud/go-microkit-plugins/log"
+	"golang.org/x/time/rate"
 )
 
 var (
 	// ErrBackPressureInit in case initialization fails
 	ErrBackPressureInit = errors.New("backpressure run context failed to initialize")
+	// ErrClosed when event is added after Shutdown or Close
+	ErrClosed = errors.New("backpressure run context is closed")
+	// ErrOptionType when typed option (e.g. DeadLetterFunc) doesn't match the event type of the PressureContext
+	ErrOptionType = errors.New("backpressure option doesn't match event type")
 )
 
 const (
@@ -39,6 +47,44 @@ type Options struct {
 	MaxWorkers        int
 	MaxBatchesInQueue int
 	Log               mclog.Logger
+	MaxAttempts       int
+	MinBackoff        time.Duration
+	MaxBackoff        time.Duration
+	RetryClassifier   RetryClassifier
+	DeadLetter        DeadLetter
+	DeadLetterFunc    interface{} // func([]T, error) for TypedPressureContext[T]
+	MaxEventsInQueue  int
+	Overflow          OverflowPolicy
+	SampleRate        int
+	Metrics           Metrics
+	BatchLogging      bool
+	TargetLatency     time.Duration
+	MinWorkers        int
+	MinBatchSize      int
+	AdaptiveInterval  time.Duration
+	SpoolDir          string
+	SpoolSegmentSize  int64
+	SpoolMaxSize      int64
+	SpoolSync         SyncPolicy
+	SpoolSyncInterval time.Duration
+	SpoolCodec        interface{} // spoolCodec[T] for TypedPressureContext[T], set with SpoolCodec
+	PartitionKey      interface{} // func(T) string for TypedPressureContext[T], set with PartitionKey
+	Partitions        int
+	BatchesPerSecond  float64
+	EventsPerSecond   float64
+	MaxInFlight       int
+	BreakerThreshold  int
+	BreakerTimeout    time.Duration
+	Reducer           interface{} // func([]T) []T for TypedPressureContext[T], set with Reducer
+	BatchMaxBytes     int
+	SizeEstimator     interface{} // func(T) int for TypedPressureContext[T], set with SizeEstimator
+	OversizeHandler   interface{} // func(T, int) for TypedPressureContext[T], set with OversizeHandler
+	Clock             Clock
+	OnFlush           func(trigger FlushTrigger, events int)
+	OnCollect         func()
+	Priority          interface{} // func(T) int for TypedPressureContext[T], set with Priority
+	Lanes             []Lane
+	StarvationGuard   int
 }
 
 // Option a single option
@@ -79,101 +125,526 @@ func Log(log mclog.Logger) Option {
 	}
 }
 
-// PressureContext which combines all the channels
-type PressureContext struct {
-	inputChan          chan interface{}
-	batchChan          chan []interface{}
+// Retry - PutMulti is attempted up to maxAttempts times with exponential backoff and jitter between minBackoff and maxBackoff.
+// Default is 1 attempt (no retries)
+func Retry(maxAttempts int, minBackoff, maxBackoff time.Duration) Option {
+	return func(args *Options) {
+		args.MaxAttempts = maxAttempts
+		args.MinBackoff = minBackoff
+		args.MaxBackoff = maxBackoff
+	}
+}
+
+// ErrorClassifier - decides which PutMulti errors are retryable. Default: all except errors wrapped with Permanent
+func ErrorClassifier(classifier RetryClassifier) Option {
+	return func(args *Options) {
+		args.RetryClassifier = classifier
+	}
+}
+
+// DeadLetterSink - receives batches that failed permanently or exhausted retries. Without it such batches are logged and dropped
+func DeadLetterSink(deadLetter DeadLetter) Option {
+	return func(args *Options) {
+		args.DeadLetter = deadLetter
+	}
+}
+
+// DeadLetterFunc - typed alternative to DeadLetterSink. T must match the event type of the PressureContext
+func DeadLetterFunc[T any](deadLetter func(events []T, err error)) Option {
+	return func(args *Options) {
+		args.DeadLetterFunc = deadLetter
+	}
+}
+
+// MaxEventsInQueue - size of the event queue in front of the batch collector. Default 0 (Add hands event directly to the collector)
+func MaxEventsInQueue(maxEventsInQueue int) Option {
+	return func(args *Options) {
+		args.MaxEventsInQueue = maxEventsInQueue
+	}
+}
+
+// Overflow - what Add does when the event queue is full. Default OverflowBlock
+func Overflow(policy OverflowPolicy) Option {
+	return func(args *Options) {
+		args.Overflow = policy
+	}
+}
+
+// SampleRate - with OverflowSample policy 1 in sampleRate events is still queued (blocking) while the queue is full. Default 10
+func SampleRate(sampleRate int) Option {
+	return func(args *Options) {
+		args.SampleRate = sampleRate
+	}
+}
+
+// MetricsCollector - receives queue depths, active workers and delivery results (e.g. NewPrometheusMetrics)
+func MetricsCollector(metrics Metrics) Option {
+	return func(args *Options) {
+		args.Metrics = metrics
+	}
+}
+
+// BatchLogging - logs queue sizes and delivery of every batch at Info level. Default true
+func BatchLogging(enabled bool) Option {
+	return func(args *Options) {
+		args.BatchLogging = enabled
+	}
+}
+
+// Adaptive - grows and shrinks the worker pool between MinWorkers and Workers based on batch queue depth and PutMulti latency,
+// and tunes batch size between MinBatchSize and BatchMaxSize toward targetLatency of PutMulti (additive increase, multiplicative decrease)
+func Adaptive(targetLatency time.Duration) Option {
+	return func(args *Options) {
+		args.TargetLatency = targetLatency
+	}
+}
+
+// MinWorkers - lower bound of the worker pool in adaptive mode. Default 1
+func MinWorkers(minWorkers int) Option {
+	return func(args *Options) {
+		args.MinWorkers = minWorkers
+	}
+}
+
+// MinBatchSize - lower bound of the batch size in adaptive mode. Default 1
+func MinBatchSize(minBatchSize int) Option {
+	return func(args *Options) {
+		args.MinBatchSize = minBatchSize
+	}
+}
+
+// AdaptiveInterval - how often worker pool is resized in adaptive mode. Default 1 second
+func AdaptiveInterval(interval time.Duration) Option {
+	return func(args *Options) {
+		args.AdaptiveInterval = interval
+	}
+}
+
+// Spool - write-ahead log in dir. Added events are appended to segment files and acknowledged after PutMulti succeeds
+// (or batch is handed over to dead letter sink). Unacknowledged events are replayed on restart (at-least-once delivery)
+func Spool(dir string) Option {
+	return func(args *Options) {
+		args.SpoolDir = dir
+	}
+}
+
+// SpoolSegmentSize - size of a spool segment file in bytes before a new one is started. Default 64MB
+func SpoolSegmentSize(segmentSize int64) Option {
+	return func(args *Options) {
+		args.SpoolSegmentSize = segmentSize
+	}
+}
+
+// SpoolMaxSize - maximum size of unacknowledged spool segments in bytes. Add returns ErrSpoolFull when reached. Default 1GB, 0 unlimited
+func SpoolMaxSize(maxSize int64) Option {
+	return func(args *Options) {
+		args.SpoolMaxSize = maxSize
+	}
+}
+
+// SpoolSync - when spool is fsynced (SyncAlways, SyncInterval or SyncNever). Default SyncAlways
+func SpoolSync(policy SyncPolicy, interval time.Duration) Option {
+	return func(args *Options) {
+		args.SpoolSync = policy
+		args.SpoolSyncInterval = interval
+	}
+}
+
+// SpoolCodec - encodes events to and decodes from the spool. Default JSON (untyped events are replayed as decoded JSON values)
+func SpoolCodec[T any](encode func(event T) ([]byte, error), decode func(data []byte) (T, error)) Option {
+	return func(args *Options) {
+		args.SpoolCodec = spoolCodec[T]{encode: encode, decode: decode}
+	}
+}
+
+// PressureContext which combines all the channels, untyped (interface{}) events
+type PressureContext = TypedPressureContext[interface{}]
+
+// TypedPressureContext which combines all the channels. T is the event type
+type TypedPressureContext[T any] struct {
+	inputChan          chan record[T]
+	batchChans         []chan batch[T] // one shared queue or one queue per partition
 	doneChan           chan bool
 	batchTimeMs        float64 // waiting for 1 second to collect before processing
 	batchMaxSize       int     // maximum number of events in batch
 	maxBatchesInQueue  int     // maximum number of batches that can wait to be processed
 	maxWorkers         int     // maximum number of worker routines
-	workerCount        uint64  // current worker count
+	workerCount        int64   // current worker count
 	log                mclog.Logger
-	backpressureMethod Backpressure
+	backpressureMethod TypedBackpressure[T]
+	maxAttempts        int
+	minBackoff         time.Duration
+	maxBackoff         time.Duration
+	retryClassifier    RetryClassifier
+	deadLetter         func(events []T, err error)
+	mutex              sync.RWMutex
+	closed             bool
+	closeOnce          sync.Once
+	stopChan           chan struct{}  // closed when context stops accepting events
+	addWg              sync.WaitGroup // Add calls in progress
+	workerWg           sync.WaitGroup // running consumer routines
+	accepted           uint64         // events accepted by Add
+	delivered          uint64         // events successfully delivered by PutMulti
+	deadLettered       uint64         // events handed over to dead letter sink
+	overflow           OverflowPolicy
+	sampleRate         uint64
+	overflowCount      uint64 // events that found the queue full (OverflowSample)
+	droppedNewest      uint64
+	droppedOldest      uint64
+	droppedSampled     uint64
+	metrics            Metrics
+	batchLogging       bool
+	activeWorkers      int64 // workers currently delivering a batch
+	adaptive           bool
+	targetLatency      time.Duration
+	minWorkers         int
+	minBatchSize       int
+	batchStep          int64 // additive batch size increase in adaptive mode
+	adaptiveInterval   time.Duration
+	batchSize          int64         // current batch size (batchMaxSize if not adaptive)
+	latency            int64         // moving average of PutMulti latency in nanoseconds
+	retireChan         chan struct{} // idle worker receiving from it exits
+	scalerDone         chan struct{} // closed when autoscaler exits
+	spool              *spool
+	codec              spoolCodec[T]
+	partitionKey       func(event T) string
+	batchLimiter       *rate.Limiter
+	eventLimiter       *rate.Limiter
+	inFlight           chan struct{} // PutMulti calls in progress (nil if unlimited)
+	breaker            *circuitBreaker
+	reducer            func(events []T) []T
+	collapsed          uint64 // events removed by reducer
+	batchMaxBytes      int
+	sizeOf             func(event T) int
+	oversizeHandler    func(event T, size int)
+	oversized          uint64 // events routed to oversize handler
+	clock              Clock
+	onFlush            func(trigger FlushTrigger, events int)
+	onCollect          func()
+	collected          uint64 // events taken from inputChan
+	priority           func(event T) int
+	lanes              []Lane        // batch parameters per priority, batchChans has one queue per lane
+	laneReady          chan struct{} // one token per batch queued in lanes
+	starvationGuard    int
+	lanePicks          uint64
+}
+
+// record event with its spool sequence number (0 without spool)
+type record[T any] struct {
+	event T
+	seq   uint64
+}
+
+// batch of events with their spool sequence numbers
+type batch[T any] struct {
+	events []T
+	seqs   []uint64
+	bytes  int       // estimated size, only with BatchMaxBytes
+	opened time.Time // first event added, only with priority lanes
 }
 
-// NewBackpressureContext creates a backpressure run context and kicks off 2 go routinges (consumer and collector)
+func (b *batch[T]) add(rec record[T]) {
+	b.events = append(b.events, rec.event)
+	if rec.seq > 0 {
+		b.seqs = append(b.seqs, rec.seq)
+	}
+}
+
+// NewBackpressureContext creates a backpressure run context for untyped events (compatible with the interface{} API)
 func NewBackpressureContext(backpressurePutMulti Backpressure, opts ...Option) (*PressureContext, error) {
+	return NewPressureContext[interface{}](backpressurePutMulti, opts...)
+}
+
+// NewPressureContext creates a typed backpressure run context and kicks off 2 go routinges (consumer and collector)
+func NewPressureContext[T any](backpressurePutMulti TypedBackpressure[T], opts ...Option) (*TypedPressureContext[T], error) {
 	args := &Options{
 		MaxWorkers:        100,
 		MaxBatchesInQueue: 100,
 		BatchTimeMs:       100,
 		BatchMaxSize:      50,
 		Log:               nil,
+		MaxAttempts:       1,
+		MinBackoff:        100 * time.Millisecond,
+		MaxBackoff:        10 * time.Second,
+		RetryClassifier:   DefaultRetryClassifier,
+		Overflow:          OverflowBlock,
+		SampleRate:        10,
+		BatchLogging:      true,
+		MinWorkers:        1,
+		MinBatchSize:      1,
+		AdaptiveInterval:  time.Second,
+		SpoolSegmentSize:  64 << 20,
+		SpoolMaxSize:      1 << 30,
+		SpoolSync:         SyncAlways,
+		SpoolSyncInterval: time.Second,
+		Clock:             SystemClock{},
+		StarvationGuard:   10,
 	}
 	for _, op := range opts {
 		op(args)
 	}
+	if args.SampleRate < 1 {
+		args.SampleRate = 1
+	}
+	if args.Clock == nil {
+		args.Clock = SystemClock{}
+	}
+	deadLetter, err := deadLetterFunc[T](args)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := spoolCodecOf[T](args)
+	if err != nil {
+		return nil, err
+	}
+	partitionKey, err := partitionKeyOf[T](args)
+	if err != nil {
+		return nil, err
+	}
+	reducer, err := reducerOf[T](args)
+	if err != nil {
+		return nil, err
+	}
+	sizeOf, oversizeHandler, err := sizeEstimatorOf[T](args)
+	if err != nil {
+		return nil, err
+	}
+	priority, lanes, err := priorityOf[T](args)
+	if err != nil {
+		return nil, err
+	}
 
-	runCtx := &PressureContext{
-		inputChan:          make(chan interface{}),
-		batchChan:          make(chan []interface{}, args.MaxBatchesInQueue),
+	runCtx := &TypedPressureContext[T]{
+		inputChan:          make(chan record[T], args.MaxEventsInQueue),
 		doneChan:           make(chan bool),
+		stopChan:           make(chan struct{}),
 		batchTimeMs:        float64(args.BatchTimeMs),
 		batchMaxSize:       args.BatchMaxSize,
 		maxBatchesInQueue:  args.MaxBatchesInQueue,
 		log:                args.Log,
 		backpressureMethod: backpressurePutMulti,
 		maxWorkers:         args.MaxWorkers,
+		maxAttempts:        args.MaxAttempts,
+		minBackoff:         args.MinBackoff,
+		maxBackoff:         args.MaxBackoff,
+		retryClassifier:    args.RetryClassifier,
+		deadLetter:         deadLetter,
+		overflow:           args.Overflow,
+		sampleRate:         uint64(args.SampleRate),
+		metrics:            args.Metrics,
+		batchLogging:       args.BatchLogging,
+		adaptive:           args.TargetLatency > 0,
+		targetLatency:      args.TargetLatency,
+		adaptiveInterval:   args.AdaptiveInterval,
+		batchSize:          int64(args.BatchMaxSize),
+		retireChan:         make(chan struct{}),
+		scalerDone:         make(chan struct{}),
+		codec:              codec,
+		partitionKey:       partitionKey,
+		reducer:            reducer,
+		batchMaxBytes:      args.BatchMaxBytes,
+		sizeOf:             sizeOf,
+		oversizeHandler:    oversizeHandler,
+		clock:              args.Clock,
+		onFlush:            args.OnFlush,
+		onCollect:          args.OnCollect,
+		priority:           priority,
+		lanes:              lanes,
+		starvationGuard:    args.StarvationGuard,
+	}
+	runCtx.initThrottling(args)
+	if b, ok := backpressurePutMulti.(PipelineBinder); ok {
+		b.Bind(runCtx.clock, runCtx.doneChan)
+	}
+	var pending []spoolRecord
+	if args.SpoolDir != "" {
+		runCtx.spool, pending, err = openSpool(args.SpoolDir, args.SpoolSegmentSize, args.SpoolMaxSize, args.SpoolSync, args.SpoolSyncInterval)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if runCtx.log != nil {
 		runCtx.log.Info("Running context with ", args.MaxWorkers, "workers, ", args.BatchTimeMs, "ms batch time, ", args.BatchMaxSize, " max batch size", args.MaxBatchesInQueue, " max batches in queue")
 	}
-	go runCtx.collectBatch()
+	workers := args.MaxWorkers
+	if runCtx.adaptive {
+		runCtx.minWorkers = boundInt(args.MinWorkers, 1, args.MaxWorkers)
+		runCtx.minBatchSize = boundInt(args.MinBatchSize, 1, args.BatchMaxSize)
+		runCtx.batchSize = int64(runCtx.minBatchSize)
+		runCtx.batchStep = int64(boundInt((args.BatchMaxSize-runCtx.minBatchSize)/10, 1, args.BatchMaxSize))
+		workers = runCtx.minWorkers
+	}
+	if partitionKey != nil {
+		// exactly one worker per partition, worker pool is not resized
+		partitions := args.Partitions
+		if partitions <= 0 {
+			partitions = args.MaxWorkers
+		}
+		queueSize := boundInt(args.MaxBatchesInQueue/partitions, 1, args.MaxBatchesInQueue)
+		for i := 0; i < partitions; i++ {
+			runCtx.batchChans = append(runCtx.batchChans, make(chan batch[T], queueSize))
+		}
+	} else if lanes != nil {
+		queued := 0
+		for _, l := range lanes {
+			runCtx.batchChans = append(runCtx.batchChans, make(chan batch[T], l.MaxBatchesInQueue))
+			queued += l.MaxBatchesInQueue
+		}
+		runCtx.laneReady = make(chan struct{}, queued)
+	} else {
+		runCtx.batchChans = []chan batch[T]{make(chan batch[T], args.MaxBatchesInQueue)}
+	}
+	// tickers are created before goroutines start, so a fake clock can be advanced right after construction
+	go runCtx.collectBatch(runCtx.flushTicker())
 
-	for i := 0; i < args.MaxWorkers; i++ {
-		go runCtx.consumeBatch()
+	if partitionKey != nil {
+		for _, partition := range runCtx.batchChans {
+			runCtx.startWorker(partition)
+		}
+	} else {
+		for i := 0; i < workers; i++ {
+			runCtx.startWorker(runCtx.batchChans[0])
+		}
+	}
+	if runCtx.adaptive && partitionKey == nil {
+		go runCtx.autoscale(runCtx.clock.NewTicker(runCtx.adaptiveInterval))
+	} else {
+		close(runCtx.scalerDone)
+	}
+	if len(pending) > 0 {
+		runCtx.addWg.Add(1)
+		go runCtx.replay(pending)
 	}
 
 	return runCtx, nil
 }
 
-// Add event to be handled by backpressure mechanism
-func (rc *PressureContext) Add(value interface{}) error {
-	if rc != nil {
-		rc.inputChan <- value
-	} else {
-		if rc.log != nil {
-			rc.log.Error(ErrBackPressureInit)
+// deadLetterFunc typed dead letter handler from DeadLetterFunc or DeadLetterSink option
+func deadLetterFunc[T any](args *Options) (func([]T, error), error) {
+	if args.DeadLetterFunc != nil {
+		fn, ok := args.DeadLetterFunc.(func([]T, error))
+		if !ok {
+			return nil, ErrOptionType
 		}
-		return ErrBackPressureInit
+		return fn, nil
+	}
+	if args.DeadLetter != nil {
+		deadLetter := args.DeadLetter
+		return func(events []T, err error) {
+			deadLetter.PutDeadLetter(toInterfaces(events), err)
+		}, nil
 	}
-	return nil
+	return nil, nil
 }
 
-func (rc *PressureContext) collectBatch() {
-	eventbatch := make([]interface{}, 0)
-
-	ticker := time.Tick(time.Duration(rc.batchTimeMs) * time.Millisecond)
+// Add event to be handled by backpressure mechanism. When the event queue is full the Overflow policy applies
+// (blocks by default, dropped events are counted in Dropped). Returns ErrClosed after Shutdown or Close
+func (rc *TypedPressureContext[T]) Add(value T) error {
+	if rc == nil {
+		return ErrBackPressureInit
+	}
+	if !rc.enter() {
+		return ErrClosed
+	}
+	defer rc.addWg.Done()
 
-	for {
-		// if max size reached before ticker ticks
-		if len(eventbatch) >= rc.batchMaxSize {
+	rec, err := rc.admit(value)
+	if err != nil {
+		return err
+	}
+	queued, err := rc.addRecord(rec)
+	if !queued {
+		rc.discard(rec)
+	}
+	return err
+}
 
-			rc.batchChan <- eventbatch
-			eventbatch = make([]interface{}, 0)
+// addRecord queues the record according to the Overflow policy. False if record was dropped
+func (rc *TypedPressureContext[T]) addRecord(rec record[T]) (bool, error) {
+	switch rc.overflow {
+	case OverflowDropNewest:
+		ok, err := rc.offer(rec)
+		if err == nil && !ok {
+			atomic.AddUint64(&rc.droppedNewest, 1)
 		}
+		return ok, err
+	case OverflowDropOldest:
+		return rc.addDropOldest(rec)
+	case OverflowSample:
+		ok, err := rc.offer(rec)
+		if ok || err != nil {
+			return ok, err
+		}
+		if atomic.AddUint64(&rc.overflowCount, 1)%rc.sampleRate != 0 {
+			atomic.AddUint64(&rc.droppedSampled, 1)
+			return false, nil
+		}
+	}
+	err := rc.send(context.Background(), rec)
+	return err == nil, err
+}
+
+// enter registers Add in progress, false if context no longer accepts events
+func (rc *TypedPressureContext[T]) enter() bool {
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+	if rc.closed {
+		return false
+	}
+	rc.addWg.Add(1)
+	return true
+}
 
+func (rc *TypedPressureContext[T]) collectBatch(ticker Ticker) {
+	eventbatches := make([]batch[T], len(rc.batchChans))
+	interval := rc.flushInterval()
+	defer ticker.Stop()
+
+	for {
 		select {
-		case ev, ok := <-rc.inputChan:
+		case rec, ok := <-rc.inputChan:
 			if !ok {
-				// dispatch last batch
-				if len(eventbatch) > 0 {
-					if rc.log != nil {
-						rc.log.Info("dispatching last batch before shutdown")
+				// dispatch last batches
+				for p := range eventbatches {
+					if len(eventbatches[p].events) > 0 {
+						if rc.log != nil {
+							rc.log.Info("dispatching last batch before shutdown")
+						}
+						if !rc.dispatch(p, eventbatches[p], FlushShutdown) {
+							return
+						}
 					}
-					rc.batchChan <- eventbatch
+				}
+				// consumers drain remaining batches and exit
+				for _, batchChan := range rc.batchChans {
+					close(batchChan)
+				}
+				if rc.laneReady != nil {
+					close(rc.laneReady)
 				}
 				return // exit consumer
 			}
-			eventbatch = append(eventbatch, ev)
+			if !rc.collect(eventbatches, rec) {
+				return
+			}
+			atomic.AddUint64(&rc.collected, 1)
+			if rc.onCollect != nil {
+				rc.onCollect()
+			}
 
-		case <-ticker:
-			if len(eventbatch) > 0 {
-				rc.batchChan <- eventbatch
-				// reset event batch
-				eventbatch = make([]interface{}, 0)
+		case <-ticker.C():
+			if rc.metrics != nil {
+				rc.metrics.EventQueueDepth(len(rc.inputChan))
+				rc.metrics.BatchQueueDepth(rc.batchQueueLength())
+			}
+			for p := range eventbatches {
+				if len(eventbatches[p].events) > 0 && rc.due(p, eventbatches[p], interval) {
+					if !rc.dispatch(p, eventbatches[p], FlushTimer) {
+						return
+					}
+					// reset event batch
+					eventbatches[p] = batch[T]{}
+				}
 			}
 		case <-rc.doneChan:
 			return
@@ -181,56 +652,293 @@ func (rc *PressureContext) collectBatch() {
 	}
 }
 
-func (rc *PressureContext) consumeBatch() {
-	for {
+// collect adds record to its partition batch and dispatches the batch once it's full, false if context was closed
+func (rc *TypedPressureContext[T]) collect(eventbatches []batch[T], rec record[T]) bool {
+	p := rc.partition(rec.event)
+	size := 0
+	if rc.batchMaxBytes > 0 {
+		size = rc.sizeOf(rec.event)
+		// close current batch if the event doesn't fit
+		if len(eventbatches[p].events) > 0 && (size > rc.batchMaxBytes || eventbatches[p].bytes+size > rc.batchMaxBytes) {
+			if !rc.dispatch(p, eventbatches[p], FlushBytes) {
+				return false
+			}
+			eventbatches[p] = batch[T]{}
+		}
+		if size > rc.batchMaxBytes && rc.oversize(rec, size) {
+			return true
+		}
+	}
+	if rc.lanes != nil && len(eventbatches[p].events) == 0 {
+		eventbatches[p].opened = rc.clock.Now()
+	}
+	eventbatches[p].add(rec)
+	eventbatches[p].bytes += size
+
+	// if max size reached before ticker ticks
+	maxSize := rc.BatchSize()
+	if rc.lanes != nil {
+		maxSize = rc.lanes[p].BatchMaxSize
+	}
+	trigger := FlushSize
+	if rc.batchMaxBytes > 0 && eventbatches[p].bytes >= rc.batchMaxBytes {
+		trigger = FlushBytes
+	} else if len(eventbatches[p].events) < maxSize {
+		return true
+	}
+	if !rc.dispatch(p, eventbatches[p], trigger) {
+		return false
+	}
+	eventbatches[p] = batch[T]{}
+	return true
+}
 
+func (rc *TypedPressureContext[T]) dispatch(partition int, eventbatch batch[T], trigger FlushTrigger) bool {
+	if rc.onFlush != nil {
+		rc.onFlush(trigger, len(eventbatch.events))
+	}
+	select {
+	case rc.batchChans[partition] <- eventbatch:
+	case <-rc.doneChan:
+		return false
+	}
+	if rc.laneReady != nil {
 		select {
-		case eb, ok := <-rc.batchChan:
-			if !ok {
-				if rc.log != nil {
-					rc.log.Info("batch writer complete", ok)
-				}
-				return
-			}
+		case rc.laneReady <- struct{}{}:
+		case <-rc.doneChan:
+			return false
+		}
+	}
+	return true
+}
 
-			eventQueueLength := len(rc.inputChan)
-			batchQueueLength := len(rc.batchChan)
+// batchQueueLength number of batches waiting for workers
+func (rc *TypedPressureContext[T]) batchQueueLength() int {
+	length := 0
+	for _, batchChan := range rc.batchChans {
+		length += len(batchChan)
+	}
+	return length
+}
 
+func (rc *TypedPressureContext[T]) consumeBatch(batchChan chan batch[T]) {
+	defer rc.workerWg.Done()
+	defer atomic.AddInt64(&rc.workerCount, -1)
+	for {
+		eb, ok := rc.nextBatch(batchChan)
+		if !ok {
+			return
+		}
+
+		eventQueueLength := len(rc.inputChan)
+		batchQueueLength := rc.batchQueueLength()
+
+		if rc.metrics != nil {
+			rc.metrics.EventQueueDepth(eventQueueLength)
+			rc.metrics.BatchQueueDepth(batchQueueLength)
+		}
+
+		if rc.log != nil && rc.batchLogging {
 			if eventQueueLength > int(math.Round(monitorWarningStart*float64(rc.batchMaxSize))) ||
 				batchQueueLength > int(math.Round(monitorWarningStart*float64(rc.maxBatchesInQueue))) {
 
-				if rc.log != nil {
-					rc.log.Warn("WARNING:", "Batch queues almost full", "event queue size: ", eventQueueLength, "batch queue size: ", batchQueueLength)
-				}
+				rc.log.Warn("WARNING:", "Batch queues almost full", "event queue size: ", eventQueueLength, "batch queue size: ", batchQueueLength)
 			} else {
-				if rc.log != nil {
-					rc.log.Info("Current event channel size", eventQueueLength, "Current batch queue size", batchQueueLength)
-				}
+				rc.log.Info("Current event channel size", eventQueueLength, "Current batch queue size", batchQueueLength)
 			}
 
-			if rc.log != nil {
-				rc.log.Info(fmt.Sprintf("batch of size %v delivered to processing (PutMulti) %v\n", len(eb), time.Now()))
-			}
+			rc.log.Info(fmt.Sprintf("batch of size %v delivered to processing (PutMulti) %v\n", len(eb.events), time.Now()))
+		}
 
-			err := rc.backpressureMethod.PutMulti(eb)
-			if err != nil {
-				if rc.log != nil {
-					rc.log.Error("failed to consumer events", err)
-				}
-			}
+		if !rc.reduce(&eb) {
+			continue
+		}
+		rc.setActiveWorkers(1)
+		rc.deliver(eb)
+		rc.setActiveWorkers(-1)
+	}
+}
 
-		case <-rc.doneChan:
-			if rc.log != nil {
-				rc.log.Info("Shutting down backpressure")
+// nextBatch waits for a batch, false if worker should exit (queue closed, worker retired or context closed)
+func (rc *TypedPressureContext[T]) nextBatch(batchChan chan batch[T]) (batch[T], bool) {
+	if rc.laneReady != nil {
+		return rc.nextLaneBatch()
+	}
+	select {
+	case eb, ok := <-batchChan:
+		if !ok && rc.log != nil {
+			rc.log.Info("batch writer complete", ok)
+		}
+		return eb, ok
+	case <-rc.retireChan:
+		return batch[T]{}, false
+	case <-rc.doneChan:
+		if rc.log != nil {
+			rc.log.Info("Shutting down backpressure")
+		}
+		return batch[T]{}, false
+	}
+}
+
+// setActiveWorkers changes number of workers delivering a batch by delta
+func (rc *TypedPressureContext[T]) setActiveWorkers(delta int64) {
+	active := atomic.AddInt64(&rc.activeWorkers, delta)
+	if rc.metrics != nil {
+		rc.metrics.ActiveWorkers(int(active))
+	}
+}
+
+// deliver calls PutMulti with retries. Batches that couldn't be delivered end up in dead letter sink
+func (rc *TypedPressureContext[T]) deliver(eb batch[T]) error {
+	events := eb.events
+	var err error
+	var latency time.Duration
+	stopped := false
+	for attempt := 1; ; attempt++ {
+		if err = rc.throttle(len(events)); err != nil {
+			stopped = true
+			break
+		}
+		start := rc.clock.Now()
+		err = rc.backpressureMethod.PutMulti(events)
+		latency = rc.clock.Now().Sub(start)
+		rc.release(err)
+		if err == nil {
+			atomic.AddUint64(&rc.delivered, uint64(len(events)))
+			if rc.metrics != nil {
+				rc.metrics.BatchDelivered(len(events), latency)
 			}
-			return
+			if rc.adaptive {
+				rc.tune(latency, true)
+			}
+			rc.acknowledge(eb)
+			return nil
+		}
+		if rc.done() {
+			// PutMulti interrupted by Close (e.g. MultiSink backoff), not a delivery failure
+			stopped = true
+			break
+		}
+		if attempt >= rc.maxAttempts || !rc.retryClassifier(err) {
+			break
+		}
+		wait := backoff(attempt, rc.minBackoff, rc.maxBackoff)
+		if rc.log != nil {
+			rc.log.Warn("failed to consume events, retrying", "attempt", attempt, "wait", wait, err)
+		}
+		if !rc.sleep(wait) {
+			stopped = true
+			break
 		}
 	}
+
+	if stopped {
+		// interrupted by Close or expired Shutdown: the batch is discarded, spooled events stay unacknowledged for replay
+		if rc.log != nil {
+			rc.log.Warn("backpressure closed, discarding batch", len(events), err)
+		}
+		return err
+	}
+	if rc.metrics != nil {
+		rc.metrics.BatchFailed(len(events), latency)
+	}
+	if rc.adaptive {
+		rc.tune(latency, false)
+	}
+	if rc.deadLetter != nil {
+		rc.deadLetter(events, err)
+		atomic.AddUint64(&rc.deadLettered, uint64(len(events)))
+		rc.acknowledge(eb)
+		return err
+	}
+	if rc.log != nil {
+		rc.log.Error("failed to consumer events", err)
+	}
+	// batch is dropped, acknowledged so the spool watermark can move past it
+	rc.acknowledge(eb)
+	return err
 }
 
-// Close channels
-func (rc *PressureContext) Close() {
-	if rc != nil {
+// Shutdown stops accepting new events, flushes the in-progress batch and waits until all queued batches are delivered
+// (or handed over to dead letter sink) or ctx expires. In the latter case remaining batches are discarded as with Close.
+// Returns number of accepted events that were neither delivered nor dead lettered
+func (rc *TypedPressureContext[T]) Shutdown(ctx context.Context) (int, error) {
+	if rc == nil {
+		return 0, ErrBackPressureInit
+	}
+	if !rc.stop() {
+		return 0, ErrClosed
+	}
+	// no Add can send on inputChan anymore and worker pool is no longer resized
+	rc.addWg.Wait()
+	<-rc.scalerDone
+	close(rc.inputChan)
+
+	drained := make(chan struct{})
+	go func() {
+		rc.workerWg.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		rc.closeDone()
+		if rc.log != nil {
+			rc.log.Warn("backpressure shutdown deadline exceeded, discarding queued batches", err)
+		}
+	}
+	rc.closeSpool()
+	return rc.dropped(), err
+}
+
+// dropped accepted events that were neither delivered, dead lettered, collapsed by Reducer, routed to OversizeHandler nor evicted by OverflowDropOldest
+func (rc *TypedPressureContext[T]) dropped() int {
+	accepted := atomic.LoadUint64(&rc.accepted)
+	handled := atomic.LoadUint64(&rc.delivered) + atomic.LoadUint64(&rc.deadLettered) + atomic.LoadUint64(&rc.droppedOldest) +
+		atomic.LoadUint64(&rc.collapsed) + atomic.LoadUint64(&rc.oversized)
+	if handled >= accepted {
+		return 0
+	}
+	return int(accepted - handled)
+}
+
+// stop marks context as closed for new events, false if already stopped
+func (rc *TypedPressureContext[T]) stop() bool {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if rc.closed {
+		return false
+	}
+	rc.closed = true
+	close(rc.stopChan)
+	return true
+}
+
+func (rc *TypedPressureContext[T]) closeDone() {
+	rc.closeOnce.Do(func() {
 		close(rc.doneChan)
+	})
+}
+
+// done true after Close or expired Shutdown
+func (rc *TypedPressureContext[T]) done() bool {
+	select {
+	case <-rc.doneChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops immediately. In-progress and queued batches are discarded (see Shutdown for graceful drain).
+// With Spool they remain unacknowledged and are replayed on restart
+func (rc *TypedPressureContext[T]) Close() {
+	if rc != nil {
+		rc.stop()
+		rc.closeDone()
+		rc.closeSpool()
 	}
 }
@@ -15,8 +15,26 @@
 package backpressure
 
 // Backpressure interface (required implementation)
-type Backpressure interface {
+type Backpressure = TypedBackpressure[interface{}]
+
+// TypedBackpressure interface (required implementation of NewPressureContext). T is the event type
+type TypedBackpressure[T any] interface {
 
 	// PutMulti handles 0, 1 or more events sent
-	PutMulti(events []interface{}) error
+	PutMulti(events []T) error
+}
+
+// DeadLetter interface (optional implementation). TypedPressureContext[T] hands over events as []interface{}, see DeadLetterFunc for typed alternative
+type DeadLetter interface {
+
+	// PutDeadLetter receives batch that failed permanently or exhausted all retries with the last error
+	PutDeadLetter(events []interface{}, err error)
+}
+
+// PipelineBinder interface (optional implementation). Backpressure implementations that wait on their own (e.g. MultiSink retries)
+// share the clock of the pipeline and stop waiting when it's closed
+type PipelineBinder interface {
+
+	// Bind is called by NewPressureContext with the pipeline clock and a channel closed on Close or expired Shutdown
+	Bind(clock Clock, done <-chan bool)
 }
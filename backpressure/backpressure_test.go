@@ -15,8 +15,10 @@
 package backpressure
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -53,6 +55,7 @@ func (bw *batchWorker) PutMulti(events []interface{}) error {
 
 func TestBackpressure(t *testing.T) {
 
+	atomic.StoreUint64(&numberOfProcessed, 0)
 	bw := &batchWorker{}
 	zl, err := mclog.NewZapLogger("info")
 	if err != nil {
@@ -91,14 +94,144 @@ func TestBackpressure(t *testing.T) {
 		}
 	}
 
-	// wait to complete the test
-	delay := time.Duration(bckPress.batchTimeMs*2) * time.Millisecond
-	time.Sleep(delay)
+	// wait for all batches to complete instead of sleeping
+	if _, err := bckPress.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
 
-	if uint64(numEventsSent) != numberOfProcessed {
-		t.Fatalf("expected to ingest %d events but only %d ingested", numEventsToSend, numberOfProcessed)
+	if processed := atomic.LoadUint64(&numberOfProcessed); uint64(numEventsSent) != processed {
+		t.Fatalf("expected to ingest %d events but only %d ingested", numEventsSent, processed)
 	}
 
 	fmt.Printf("Processed all in %v\n", time.Since(now))
 
 }
+
+func TestShutdownDrain(t *testing.T) {
+	fw := &flakyWorker{}
+	bp, err := NewBackpressureContext(fw, BatchMaxSize(3), BatchTimeMs(10000), Workers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := bp.Add(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dropped, err := bp.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 0 || fw.received != 10 {
+		t.Fatalf("expected all 10 events delivered, got %v delivered and %v dropped", fw.received, dropped)
+	}
+	if err := bp.Add(11); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := bp.Shutdown(context.Background()); err != ErrClosed {
+		t.Fatalf("expected ErrClosed on second shutdown, got %v", err)
+	}
+}
+
+// blockingWorker blocks PutMulti until released
+type blockingWorker struct {
+	release chan struct{}
+}
+
+func (bw *blockingWorker) PutMulti(events []interface{}) error {
+	<-bw.release
+	return nil
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	bw := &blockingWorker{release: make(chan struct{})}
+	defer close(bw.release)
+
+	bp, err := NewBackpressureContext(bw, BatchMaxSize(2), BatchTimeMs(10000), Workers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := bp.Add(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	dropped, err := bp.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if dropped != 5 {
+		t.Fatalf("expected 5 dropped events, got %v", dropped)
+	}
+}
+
+type typedWorker struct {
+	mutex  sync.Mutex
+	events []event
+}
+
+func (tw *typedWorker) PutMulti(events []event) error {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	tw.events = append(tw.events, events...)
+	return nil
+}
+
+func TestTypedPressureContext(t *testing.T) {
+	tw := &typedWorker{}
+	var deadLettered []event
+	bp, err := NewPressureContext[event](tw, BatchMaxSize(2), BatchTimeMs(10), Workers(1), DeadLetterFunc(func(events []event, err error) {
+		deadLettered = append(deadLettered, events...)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := bp.Add(event{name: fmt.Sprintf("event_%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+	if len(tw.events) != 3 || tw.events[2].name != "event_2" || len(deadLettered) != 0 {
+		t.Fatalf("expected 3 typed events delivered, got %v", tw.events)
+	}
+
+	if _, err := NewPressureContext[event](tw, DeadLetterFunc(func(events []string, err error) {})); err != ErrOptionType {
+		t.Fatalf("expected ErrOptionType, got %v", err)
+	}
+}
+
+func TestBatchTimeZero(t *testing.T) {
+	tw := &typedWorker{}
+	var mutex sync.Mutex
+	var triggers []FlushTrigger
+	bp, err := NewPressureContext[event](tw, BatchMaxSize(2), BatchTimeMs(0), Workers(1), OnFlush(func(trigger FlushTrigger, events int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		triggers = append(triggers, trigger)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := bp.Add(event{name: fmt.Sprintf("event_%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the last event waits for a full batch or Shutdown
+	time.Sleep(50 * time.Millisecond)
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(triggers) != 2 || triggers[0] != FlushSize || triggers[1] != FlushShutdown {
+		t.Fatalf("expected size and shutdown flush, got %v", triggers)
+	}
+}
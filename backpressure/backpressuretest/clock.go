@@ -0,0 +1,168 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backpressuretest provides a fake clock and a harness to test backpressure.PressureContext and PutMulti
+// implementations deterministically, without sleeps
+package backpressuretest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/backpressure"
+)
+
+// FakeClock - backpressure.Clock that only moves forward with Advance
+type FakeClock struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter fake ticker or timer
+type waiter struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration // 0 for timers
+}
+
+// NewFakeClock clock starting at start
+func NewFakeClock(start time.Time) *FakeClock {
+	fc := &FakeClock{now: start}
+	fc.cond = sync.NewCond(&fc.mutex)
+	return fc
+}
+
+// Now current fake time
+func (fc *FakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.now
+}
+
+// NewTicker ticker firing every d of fake time. Like time.Ticker it drops ticks for slow receivers
+func (fc *FakeClock) NewTicker(d time.Duration) backpressure.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return &fakeTicker{fc.add(d, d)}
+}
+
+// NewTimer timer firing once after d of fake time
+func (fc *FakeClock) NewTimer(d time.Duration) backpressure.Timer {
+	return &fakeTimer{fc.add(d, 0)}
+}
+
+// Advance moves time forward by d and fires due tickers and timers
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.now = fc.now.Add(d)
+	pending := fc.waiters[:0]
+	for _, w := range fc.waiters {
+		if w.when.After(fc.now) {
+			pending = append(pending, w)
+			continue
+		}
+		select {
+		case w.c <- fc.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.when.After(fc.now) {
+				w.when = w.when.Add(w.period)
+			}
+			pending = append(pending, w)
+		}
+	}
+	fc.waiters = pending
+}
+
+// Timers number of timers waiting to fire (e.g. retry backoff or rate limit waits)
+func (fc *FakeClock) Timers() int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.timers()
+}
+
+// BlockUntilTimers blocks until at least n timers are waiting to fire
+func (fc *FakeClock) BlockUntilTimers(n int) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	for fc.timers() < n {
+		fc.cond.Wait()
+	}
+}
+
+func (fc *FakeClock) timers() int {
+	count := 0
+	for _, w := range fc.waiters {
+		if w.period == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func (fc *FakeClock) add(d, period time.Duration) *waiter {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	w := &waiter{clock: fc, c: make(chan time.Time, 1), when: fc.now.Add(d), period: period}
+	if d <= 0 {
+		w.c <- fc.now
+		return w
+	}
+	fc.waiters = append(fc.waiters, w)
+	fc.cond.Broadcast()
+	return w
+}
+
+// remove waiter, false if it already fired or was removed
+func (fc *FakeClock) remove(w *waiter) bool {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	for i, other := range fc.waiters {
+		if other == w {
+			fc.waiters = append(fc.waiters[:i], fc.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct {
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.w.clock.remove(t.w)
+}
+
+type fakeTimer struct {
+	w *waiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.w.clock.remove(t.w)
+}
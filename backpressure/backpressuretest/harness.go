@@ -0,0 +1,204 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressuretest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/backpressure"
+)
+
+// DefaultTimeout how long Harness waits (in real time) for events, batches and flushes before failing the test
+var DefaultTimeout = 5 * time.Second
+
+// Batch - a single PutMulti call seen by Harness
+type Batch[T any] struct {
+	Events []T
+	Err    error // returned by PutMulti
+}
+
+// Harness - PressureContext running on a FakeClock that records PutMulti calls and flush triggers
+type Harness[T any] struct {
+	Clock   *FakeClock
+	Context *backpressure.TypedPressureContext[T]
+	Timeout time.Duration
+
+	t        testing.TB
+	sink     backpressure.TypedBackpressure[T]
+	mutex    sync.Mutex
+	changed  chan struct{} // closed and replaced on every collected event, recorded batch or flush
+	batches  []Batch[T]
+	triggers []backpressure.FlushTrigger
+	added    uint64
+}
+
+// NewHarness creates PressureContext with opts on a FakeClock. PutMulti calls go to sink (nil accepts all batches).
+// The context is closed when the test ends
+func NewHarness[T any](t testing.TB, sink backpressure.TypedBackpressure[T], opts ...backpressure.Option) *Harness[T] {
+	t.Helper()
+	h := &Harness[T]{
+		Clock:   NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Timeout: DefaultTimeout,
+		t:       t,
+		sink:    sink,
+		changed: make(chan struct{}),
+	}
+	// keep OnFlush hook from opts
+	args := &backpressure.Options{}
+	for _, op := range opts {
+		op(args)
+	}
+	hook, collectHook := args.OnFlush, args.OnCollect
+
+	opts = append([]backpressure.Option{backpressure.ClockSource(h.Clock)}, opts...)
+	opts = append(opts, backpressure.OnFlush(func(trigger backpressure.FlushTrigger, events int) {
+		if hook != nil {
+			hook(trigger, events)
+		}
+		h.record(func() {
+			h.triggers = append(h.triggers, trigger)
+		})
+	}), backpressure.OnCollect(func() {
+		if collectHook != nil {
+			collectHook()
+		}
+		h.record(func() {})
+	}))
+	bp, err := backpressure.NewPressureContext[T](h, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Context = bp
+	t.Cleanup(bp.Close)
+	return h
+}
+
+// PutMulti records the batch and passes it to the sink
+func (h *Harness[T]) PutMulti(events []T) error {
+	var err error
+	if h.sink != nil {
+		err = h.sink.PutMulti(events)
+	}
+	h.record(func() {
+		h.batches = append(h.batches, Batch[T]{Events: append([]T(nil), events...), Err: err})
+	})
+	return err
+}
+
+// Bind passes the fake clock and close signal of the pipeline to the sink (e.g. MultiSink)
+func (h *Harness[T]) Bind(clock backpressure.Clock, done <-chan bool) {
+	if b, ok := h.sink.(backpressure.PipelineBinder); ok {
+		b.Bind(clock, done)
+	}
+}
+
+// Add adds events and waits until all of them reached a batch (or were dropped by the overflow policy),
+// so a following Advance flushes them
+func (h *Harness[T]) Add(events ...T) {
+	h.t.Helper()
+	for _, ev := range events {
+		if err := h.Context.Add(ev); err != nil {
+			h.t.Fatal(err)
+		}
+		h.mutex.Lock()
+		h.added++
+		h.mutex.Unlock()
+	}
+	h.mutex.Lock()
+	added := h.added
+	h.mutex.Unlock()
+	// overflow drops are counted within Context.Add, collected events are recorded by OnCollect
+	h.wait(func() bool {
+		return h.Context.Collected()+h.Context.Dropped().Total() >= h.added
+	}, "collected events", int(added))
+}
+
+// Advance moves the fake clock forward by d
+func (h *Harness[T]) Advance(d time.Duration) {
+	h.Clock.Advance(d)
+}
+
+// Batches PutMulti calls so far
+func (h *Harness[T]) Batches() []Batch[T] {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Batch[T](nil), h.batches...)
+}
+
+// Flushes flush triggers so far, in order batches were closed
+func (h *Harness[T]) Flushes() []backpressure.FlushTrigger {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]backpressure.FlushTrigger(nil), h.triggers...)
+}
+
+// WaitBatches waits for at least n PutMulti calls and returns them
+func (h *Harness[T]) WaitBatches(n int) []Batch[T] {
+	h.t.Helper()
+	h.wait(func() bool { return len(h.batches) >= n }, "batches", n)
+	return h.Batches()
+}
+
+// WaitFlushes waits for at least n flushes and returns their triggers
+func (h *Harness[T]) WaitFlushes(n int) []backpressure.FlushTrigger {
+	h.t.Helper()
+	h.wait(func() bool { return len(h.triggers) >= n }, "flushes", n)
+	return h.Flushes()
+}
+
+// Shutdown drains the context and fails the test if events were dropped
+func (h *Harness[T]) Shutdown() {
+	h.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	dropped, err := h.Context.Shutdown(ctx)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if dropped > 0 {
+		h.t.Fatalf("%v events dropped on shutdown", dropped)
+	}
+}
+
+func (h *Harness[T]) record(update func()) {
+	h.mutex.Lock()
+	update()
+	close(h.changed)
+	h.changed = make(chan struct{})
+	h.mutex.Unlock()
+}
+
+func (h *Harness[T]) wait(done func() bool, what string, n int) {
+	h.t.Helper()
+	timeout := time.NewTimer(h.Timeout)
+	defer timeout.Stop()
+	for {
+		h.mutex.Lock()
+		if done() {
+			h.mutex.Unlock()
+			return
+		}
+		changed := h.changed
+		h.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-timeout.C:
+			h.t.Fatalf("timeout waiting for %v %v", n, what)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressuretest
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chryscloud/go-microkit-plugins/backpressure"
+)
+
+func TestFakeClock(t *testing.T) {
+	fc := NewFakeClock(time.Unix(0, 0))
+	ticker := fc.NewTicker(time.Second)
+	timer := fc.NewTimer(3 * time.Second)
+	if fc.Timers() != 1 {
+		t.Fatalf("expected 1 timer, got %v", fc.Timers())
+	}
+
+	fc.Advance(999 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired early")
+	default:
+	}
+	fc.Advance(time.Millisecond)
+	if tick := <-ticker.C(); !tick.Equal(time.Unix(1, 0)) {
+		t.Fatalf("expected tick at 1s, got %v", tick)
+	}
+
+	// slow receiver misses ticks
+	fc.Advance(5 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("expected dropped ticks")
+	default:
+	}
+	<-timer.C()
+	if timer.Stop() || fc.Timers() != 0 {
+		t.Fatal("expected fired timer to be removed")
+	}
+	ticker.Stop()
+}
+
+func TestHarnessFlushTriggers(t *testing.T) {
+	h := NewHarness[int](t, nil, backpressure.BatchMaxSize(3), backpressure.BatchTimeMs(100), backpressure.Workers(1))
+
+	h.Add(1, 2, 3)
+	if batches := h.WaitBatches(1); len(batches[0].Events) != 3 {
+		t.Fatalf("expected full batch, got %v", batches[0].Events)
+	}
+
+	h.Add(4)
+	h.Advance(99 * time.Millisecond)
+	if flushes := h.Flushes(); len(flushes) != 1 {
+		t.Fatalf("expected no timer flush before batch time, got %v", flushes)
+	}
+	h.Advance(time.Millisecond)
+	batches := h.WaitBatches(2)
+	if len(batches[1].Events) != 1 || batches[1].Events[0] != 4 {
+		t.Fatalf("expected batch with event 4, got %v", batches[1].Events)
+	}
+
+	h.Add(5)
+	h.Shutdown()
+	flushes := h.WaitFlushes(3)
+	expected := []backpressure.FlushTrigger{backpressure.FlushSize, backpressure.FlushTimer, backpressure.FlushShutdown}
+	for i := range expected {
+		if flushes[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, flushes)
+		}
+	}
+}
+
+type failingSink struct {
+	failures int64
+}
+
+func (fs *failingSink) PutMulti(events []int) error {
+	if atomic.AddInt64(&fs.failures, -1) >= 0 {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestHarnessRetryBackoff(t *testing.T) {
+	h := NewHarness[int](t, &failingSink{failures: 2}, backpressure.BatchMaxSize(2), backpressure.Workers(1),
+		backpressure.Retry(5, time.Second, time.Minute))
+
+	h.Add(1, 2)
+	for i := 0; i < 2; i++ {
+		// worker waits for backoff on the fake clock
+		h.Clock.BlockUntilTimers(1)
+		h.Advance(time.Minute)
+	}
+	batches := h.WaitBatches(3)
+	if batches[0].Err == nil || batches[1].Err == nil || batches[2].Err != nil {
+		t.Fatalf("expected 2 failed attempts followed by success, got %v", batches)
+	}
+	h.Shutdown()
+}
+
+func TestHarnessMultiSinkBackoff(t *testing.T) {
+	ms := backpressure.NewMultiSink([]*backpressure.Sink[int]{
+		backpressure.NewSink[int]("db", &failingSink{failures: 1}, backpressure.SinkRetry(2, time.Hour, time.Hour)),
+	})
+	h := NewHarness[int](t, ms, backpressure.BatchMaxSize(2), backpressure.Workers(1))
+
+	h.Add(1, 2)
+	// sink retry waits on the fake clock
+	h.Clock.BlockUntilTimers(1)
+	h.Advance(time.Hour)
+	if batches := h.WaitBatches(1); batches[0].Err != nil {
+		t.Fatalf("expected batch delivered on second sink attempt, got %v", batches[0].Err)
+	}
+	h.Shutdown()
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock - source of time for batching ticker, retry backoff, rate limits, circuit breaker and adaptive mode.
+// Defaults to the system clock, backpressuretest.FakeClock makes tests deterministic
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+}
+
+// Ticker - ticker created by Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer - timer created by Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// ClockSource - replaces the system clock (e.g. in tests)
+func ClockSource(clock Clock) Option {
+	return func(args *Options) {
+		args.Clock = clock
+	}
+}
+
+// SystemClock - Clock backed by the time package
+type SystemClock struct{}
+
+// Now current time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker time.NewTicker
+func (SystemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+// NewTimer time.NewTimer
+func (SystemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// idleTicker never fires, batches are flushed by size only
+type idleTicker struct{}
+
+func (idleTicker) C() <-chan time.Time {
+	return nil
+}
+
+func (idleTicker) Stop() {}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t systemTicker) Stop() {
+	t.ticker.Stop()
+}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// FlushTrigger reason a batch was closed and queued for PutMulti
+type FlushTrigger int
+
+const (
+	// FlushSize batch reached BatchMaxSize events
+	FlushSize FlushTrigger = iota
+	// FlushBytes batch reached BatchMaxBytes
+	FlushBytes
+	// FlushTimer BatchTimeMs elapsed
+	FlushTimer
+	// FlushShutdown remaining events on Shutdown
+	FlushShutdown
+)
+
+func (t FlushTrigger) String() string {
+	switch t {
+	case FlushSize:
+		return "size"
+	case FlushBytes:
+		return "bytes"
+	case FlushTimer:
+		return "timer"
+	case FlushShutdown:
+		return "shutdown"
+	default:
+		return "unknown"
+	}
+}
+
+// OnFlush - called from the batching goroutine every time a batch is closed, with the trigger and the number of events
+func OnFlush(hook func(trigger FlushTrigger, events int)) Option {
+	return func(args *Options) {
+		args.OnFlush = hook
+	}
+}
+
+// OnCollect - called from the batching goroutine every time an event is taken from the event queue (see Collected)
+func OnCollect(hook func()) Option {
+	return func(args *Options) {
+		args.OnCollect = hook
+	}
+}
+
+// Collected number of events taken from the event queue by the batching goroutine.
+// Together with Dropped it tells when all added events reached a batch
+func (rc *TypedPressureContext[T]) Collected() uint64 {
+	return atomic.LoadUint64(&rc.collected)
+}
+
+// flushTicker batching ticker, never fires if BatchTimeMs is not positive
+func (rc *TypedPressureContext[T]) flushTicker() Ticker {
+	interval := rc.flushInterval()
+	if interval <= 0 {
+		return idleTicker{}
+	}
+	return rc.clock.NewTicker(interval)
+}
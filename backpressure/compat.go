@@ -0,0 +1,65 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrEventType when untyped event doesn't match the event type of the typed Backpressure implementation
+	ErrEventType = errors.New("event doesn't match backpressure event type")
+)
+
+// untyped adapts TypedBackpressure[T] to the interface{} API
+type untyped[T any] struct {
+	backpressure TypedBackpressure[T]
+}
+
+// Untyped adapts typed PutMulti implementation to be used with NewBackpressureContext (interface{} API).
+// Batch with an event of other type fails with Permanent ErrEventType and is not retried
+func Untyped[T any](backpressure TypedBackpressure[T]) Backpressure {
+	return &untyped[T]{backpressure: backpressure}
+}
+
+// PutMulti asserts events to T and calls typed PutMulti
+func (u *untyped[T]) PutMulti(events []interface{}) error {
+	typed := make([]T, len(events))
+	for i, ev := range events {
+		t, ok := ev.(T)
+		if !ok {
+			return Permanent(fmt.Errorf("%w: %T", ErrEventType, ev))
+		}
+		typed[i] = t
+	}
+	return u.backpressure.PutMulti(typed)
+}
+
+// Bind passes pipeline clock and close signal to the typed implementation (e.g. MultiSink)
+func (u *untyped[T]) Bind(clock Clock, done <-chan bool) {
+	if b, ok := u.backpressure.(PipelineBinder); ok {
+		b.Bind(clock, done)
+	}
+}
+
+// toInterfaces converts typed events to []interface{}
+func toInterfaces[T any](events []T) []interface{} {
+	untyped := make([]interface{}, len(events))
+	for i, ev := range events {
+		untyped[i] = ev
+	}
+	return untyped
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestUntyped(t *testing.T) {
+	tw := &typedWorker{}
+	dl := &deadLetterCollector{}
+	bp, err := NewBackpressureContext(Untyped[event](tw), BatchMaxSize(1), BatchTimeMs(10), Workers(1), DeadLetterSink(dl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.Add(event{name: "typed"})
+	bp.Add("not an event")
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+	if len(tw.events) != 1 || tw.events[0].name != "typed" {
+		t.Fatalf("expected 1 typed event delivered, got %v", tw.events)
+	}
+	if len(dl.events) != 1 || !errors.Is(dl.errs[0], ErrEventType) || !IsPermanent(dl.errs[0]) {
+		t.Fatalf("expected mismatched event in dead letter sink, got %v, %v", dl.events, dl.errs)
+	}
+}
+
+// the interface{} API keeps its original non-generic names
+var (
+	_ Backpressure                                            = &batchWorker{}
+	_ func(Backpressure, ...Option) (*PressureContext, error) = NewBackpressureContext
+)
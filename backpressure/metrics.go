@@ -0,0 +1,213 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics receives observations of the backpressure pipeline (optional implementation)
+type Metrics interface {
+
+	// EventQueueDepth number of events waiting for the batch collector
+	EventQueueDepth(depth int)
+
+	// BatchQueueDepth number of batches waiting for a worker
+	BatchQueueDepth(depth int)
+
+	// ActiveWorkers number of workers currently delivering a batch
+	ActiveWorkers(count int)
+
+	// BatchDelivered batch of size delivered by PutMulti with latency of the successful call
+	BatchDelivered(size int, latency time.Duration)
+
+	// BatchFailed batch of size failed permanently or exhausted all retries with latency of the last call
+	BatchFailed(size int, latency time.Duration)
+}
+
+var (
+	// DefaultLatencyBuckets PutMulti latency histogram buckets in seconds
+	DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// DefaultBatchSizeBuckets batch size histogram buckets
+	DefaultBatchSizeBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000}
+)
+
+// PrometheusMetrics collects pipeline metrics and serves them in Prometheus text exposition format
+type PrometheusMetrics struct {
+	namespace        string
+	mutex            sync.Mutex
+	eventQueueDepth  int
+	batchQueueDepth  int
+	activeWorkers    int
+	batchesDelivered uint64
+	batchesFailed    uint64
+	eventsDelivered  uint64
+	eventsFailed     uint64
+	latency          *histogram
+	batchSize        *histogram
+}
+
+// NewPrometheusMetrics creates metrics collector with metric names prefixed by namespace (e.g. myservice_backpressure_active_workers).
+// Register it as http.Handler (e.g. on /metrics)
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	return &PrometheusMetrics{
+		namespace: namespace,
+		latency:   newHistogram(DefaultLatencyBuckets),
+		batchSize: newHistogram(DefaultBatchSizeBuckets),
+	}
+}
+
+// EventQueueDepth implements Metrics
+func (pm *PrometheusMetrics) EventQueueDepth(depth int) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.eventQueueDepth = depth
+}
+
+// BatchQueueDepth implements Metrics
+func (pm *PrometheusMetrics) BatchQueueDepth(depth int) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.batchQueueDepth = depth
+}
+
+// ActiveWorkers implements Metrics
+func (pm *PrometheusMetrics) ActiveWorkers(count int) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.activeWorkers = count
+}
+
+// BatchDelivered implements Metrics
+func (pm *PrometheusMetrics) BatchDelivered(size int, latency time.Duration) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.batchesDelivered++
+	pm.eventsDelivered += uint64(size)
+	pm.latency.observe(latency.Seconds())
+	pm.batchSize.observe(float64(size))
+}
+
+// BatchFailed implements Metrics
+func (pm *PrometheusMetrics) BatchFailed(size int, latency time.Duration) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.batchesFailed++
+	pm.eventsFailed += uint64(size)
+	pm.latency.observe(latency.Seconds())
+	pm.batchSize.observe(float64(size))
+}
+
+// ServeHTTP writes metrics in Prometheus text exposition format
+func (pm *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	pm.WriteTo(w)
+}
+
+// WriteTo writes metrics in Prometheus text exposition format
+func (pm *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	ew := &errWriter{w: w}
+	pm.gauge(ew, "event_queue_depth", "Events waiting for the batch collector", float64(pm.eventQueueDepth))
+	pm.gauge(ew, "batch_queue_depth", "Batches waiting for a worker", float64(pm.batchQueueDepth))
+	pm.gauge(ew, "active_workers", "Workers currently delivering a batch", float64(pm.activeWorkers))
+	pm.counter(ew, "batches_delivered_total", "Batches delivered by PutMulti", pm.batchesDelivered)
+	pm.counter(ew, "batches_failed_total", "Batches failed permanently or after all retries", pm.batchesFailed)
+	pm.counter(ew, "events_delivered_total", "Events delivered by PutMulti", pm.eventsDelivered)
+	pm.counter(ew, "events_failed_total", "Events in failed batches", pm.eventsFailed)
+	pm.latency.write(ew, pm.name("putmulti_duration_seconds"), "PutMulti latency in seconds")
+	pm.batchSize.write(ew, pm.name("batch_size"), "Number of events in delivered and failed batches")
+	return ew.n, ew.err
+}
+
+func (pm *PrometheusMetrics) name(metric string) string {
+	if pm.namespace == "" {
+		return metric
+	}
+	return pm.namespace + "_" + metric
+}
+
+func (pm *PrometheusMetrics) gauge(w io.Writer, metric, help string, value float64) {
+	name := pm.name(metric)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(value))
+}
+
+func (pm *PrometheusMetrics) counter(w io.Writer, metric, help string, value uint64) {
+	name := pm.name(metric)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// histogram with cumulative buckets
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// errWriter keeps first write error and number of bytes written
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	ew.n += int64(n)
+	ew.err = err
+	return n, err
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	pm := NewPrometheusMetrics("test")
+	fw := &flakyWorker{failures: 1, err: Permanent(errTemporary)}
+	bp, err := NewBackpressureContext(fw, BatchMaxSize(5), BatchTimeMs(10000), Workers(1), MetricsCollector(pm), BatchLogging(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := bp.Add(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 5 {
+		t.Fatalf("expected 5 dropped events from failed batch, got %v, %v", dropped, err)
+	}
+
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	expected := []string{
+		"# TYPE test_active_workers gauge\ntest_active_workers 0\n",
+		"test_batches_delivered_total 1\n",
+		"test_batches_failed_total 1\n",
+		"test_events_delivered_total 3\n",
+		"test_events_failed_total 5\n",
+		"# TYPE test_putmulti_duration_seconds histogram\n",
+		"test_putmulti_duration_seconds_count 2\n",
+		"test_batch_size_bucket{le=\"1\"} 0\n",
+		"test_batch_size_bucket{le=\"5\"} 2\n",
+		"test_batch_size_bucket{le=\"+Inf\"} 2\n",
+		"test_batch_size_sum 8\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(string(body), e) {
+			t.Fatalf("expected %q in metrics:\n%s", e, body)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	for _, d := range []time.Duration{50 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second} {
+		h.observe(d.Seconds())
+	}
+	var sb strings.Builder
+	h.write(&sb, "latency", "help")
+	expected := "# HELP latency help\n# TYPE latency histogram\n" +
+		"latency_bucket{le=\"0.1\"} 1\nlatency_bucket{le=\"1\"} 2\nlatency_bucket{le=\"+Inf\"} 3\n" +
+		"latency_sum 2.55\nlatency_count 3\n"
+	if sb.String() != expected {
+		t.Fatalf("unexpected histogram output:\n%s", sb.String())
+	}
+}
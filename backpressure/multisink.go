@@ -0,0 +1,295 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+)
+
+var (
+	// ErrSinkTimeout when sink PutMulti didn't complete within SinkTimeout
+	ErrSinkTimeout = errors.New("sink PutMulti timed out")
+)
+
+// SinkOptions delivery policy of a single sink in MultiSink
+type SinkOptions struct {
+	Required        bool
+	MaxAttempts     int
+	MinBackoff      time.Duration
+	MaxBackoff      time.Duration
+	Timeout         time.Duration
+	RetryClassifier RetryClassifier
+}
+
+// SinkOption a single sink option
+type SinkOption func(*SinkOptions)
+
+// SinkBestEffort - sink failure is reported but doesn't fail the batch. Sinks are required by default
+func SinkBestEffort() SinkOption {
+	return func(args *SinkOptions) {
+		args.Required = false
+	}
+}
+
+// SinkRetry - sink PutMulti is attempted up to maxAttempts times with exponential backoff and jitter. Default 1 attempt
+func SinkRetry(maxAttempts int, minBackoff, maxBackoff time.Duration) SinkOption {
+	return func(args *SinkOptions) {
+		args.MaxAttempts = maxAttempts
+		args.MinBackoff = minBackoff
+		args.MaxBackoff = maxBackoff
+	}
+}
+
+// SinkTimeout - attempt fails with ErrSinkTimeout if sink PutMulti doesn't return in time. Only ContextSink is interrupted
+// (its context is cancelled), PutMulti of other sinks keeps running in the background. Default no timeout
+func SinkTimeout(timeout time.Duration) SinkOption {
+	return func(args *SinkOptions) {
+		args.Timeout = timeout
+	}
+}
+
+// SinkErrorClassifier - decides which sink errors are retryable. Default: all except errors wrapped with Permanent
+func SinkErrorClassifier(classifier RetryClassifier) SinkOption {
+	return func(args *SinkOptions) {
+		args.RetryClassifier = classifier
+	}
+}
+
+// ContextSink - optional sink interface, PutMultiContext is called instead of PutMulti. The context is cancelled
+// on SinkTimeout and when the pipeline is closed
+type ContextSink[T any] interface {
+	PutMultiContext(ctx context.Context, events []T) error
+}
+
+// Sink a named destination of MultiSink
+type Sink[T any] struct {
+	name         string
+	backpressure TypedBackpressure[T]
+	options      SinkOptions
+}
+
+// NewSink creates named sink with delivery policy
+func NewSink[T any](name string, backpressure TypedBackpressure[T], opts ...SinkOption) *Sink[T] {
+	args := SinkOptions{
+		Required:        true,
+		MaxAttempts:     1,
+		MinBackoff:      100 * time.Millisecond,
+		MaxBackoff:      10 * time.Second,
+		RetryClassifier: DefaultRetryClassifier,
+	}
+	for _, op := range opts {
+		op(&args)
+	}
+	return &Sink[T]{name: name, backpressure: backpressure, options: args}
+}
+
+// SinkResult delivery status of a batch to a single sink
+type SinkResult struct {
+	Name     string
+	Required bool
+	Attempts int
+	Duration time.Duration
+	Err      error
+}
+
+// MultiSinkError when at least one required sink failed. Contains results of all sinks
+type MultiSinkError struct {
+	Results []SinkResult
+}
+
+func (e *MultiSinkError) Error() string {
+	failed := make([]string, 0, len(e.Results))
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed = append(failed, r.Name+": "+r.Err.Error())
+		}
+	}
+	return "sinks failed: " + strings.Join(failed, "; ")
+}
+
+// MultiSinkOptions settings of MultiSink
+type MultiSinkOptions struct {
+	Log      mclog.Logger
+	OnResult func(results []SinkResult)
+}
+
+// MultiSinkOption a single multi sink option
+type MultiSinkOption func(*MultiSinkOptions)
+
+// MultiSinkLog - logs failed sinks
+func MultiSinkLog(log mclog.Logger) MultiSinkOption {
+	return func(args *MultiSinkOptions) {
+		args.Log = log
+	}
+}
+
+// MultiSinkResults - called with per sink results after every batch
+func MultiSinkResults(onResult func(results []SinkResult)) MultiSinkOption {
+	return func(args *MultiSinkOptions) {
+		args.OnResult = onResult
+	}
+}
+
+// MultiSink delivers each batch to several sinks in parallel. Each sink retries on its own, so a batch
+// failed by a required sink is returned as Permanent MultiSinkError (pipeline retry would deliver it to successful sinks again).
+// Sink backoff and timeouts use the clock of the pipeline and are interrupted when the pipeline is closed
+type MultiSink[T any] struct {
+	sinks    []*Sink[T]
+	log      mclog.Logger
+	onResult func(results []SinkResult)
+	mutex    sync.Mutex
+	clock    Clock
+	done     <-chan bool
+}
+
+// NewMultiSink creates fan-out Backpressure implementation
+func NewMultiSink[T any](sinks []*Sink[T], opts ...MultiSinkOption) *MultiSink[T] {
+	args := &MultiSinkOptions{}
+	for _, op := range opts {
+		op(args)
+	}
+	return &MultiSink[T]{
+		sinks:    sinks,
+		log:      args.Log,
+		onResult: args.OnResult,
+		clock:    SystemClock{},
+	}
+}
+
+// Bind shares clock and close signal of the pipeline the MultiSink is passed to
+func (ms *MultiSink[T]) Bind(clock Clock, done <-chan bool) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.clock = clock
+	ms.done = done
+}
+
+// PutMulti delivers events to all sinks. Fails only if a required sink failed
+func (ms *MultiSink[T]) PutMulti(events []T) error {
+	ms.mutex.Lock()
+	clock, done := ms.clock, ms.done
+	ms.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if done != nil {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	results := make([]SinkResult, len(ms.sinks))
+	var wg sync.WaitGroup
+	for i, sink := range ms.sinks {
+		wg.Add(1)
+		go func(i int, sink *Sink[T]) {
+			defer wg.Done()
+			results[i] = sink.deliver(ctx, clock, events)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	if ms.onResult != nil {
+		ms.onResult(results)
+	}
+	failed := false
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		if ms.log != nil {
+			ms.log.Error("sink failed to consume events", r.Name, "required", r.Required, "attempts", r.Attempts, r.Err)
+		}
+		if r.Required {
+			failed = true
+		}
+	}
+	if failed {
+		return Permanent(&MultiSinkError{Results: results})
+	}
+	return nil
+}
+
+// deliver events with sink retry and timeout policy, until ctx is cancelled
+func (s *Sink[T]) deliver(ctx context.Context, clock Clock, events []T) SinkResult {
+	result := SinkResult{Name: s.name, Required: s.options.Required}
+	start := clock.Now()
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		result.Err = s.attempt(ctx, clock, events)
+		if result.Err == nil || attempt >= s.options.MaxAttempts || !s.options.RetryClassifier(result.Err) {
+			break
+		}
+		if !sleepContext(ctx, clock, backoff(attempt, s.options.MinBackoff, s.options.MaxBackoff)) {
+			break
+		}
+	}
+	result.Duration = clock.Now().Sub(start)
+	return result
+}
+
+func (s *Sink[T]) attempt(ctx context.Context, clock Clock, events []T) error {
+	if s.options.Timeout <= 0 {
+		return s.put(ctx, events)
+	}
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.put(attemptCtx, events)
+	}()
+	timer := clock.NewTimer(s.options.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C():
+		return ErrSinkTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sink[T]) put(ctx context.Context, events []T) error {
+	if cs, ok := s.backpressure.(ContextSink[T]); ok {
+		return cs.PutMultiContext(ctx, events)
+	}
+	return s.backpressure.PutMulti(events)
+}
+
+// sleepContext sleeps for d on clock, false if ctx was cancelled meanwhile
+func sleepContext(ctx context.Context, clock Clock, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiSink(t *testing.T) {
+	db := &flakyWorker{failures: 1, err: errTemporary}
+	archive := &flakyWorker{failures: 100, err: errTemporary}
+	slow := &delayWorker{delay: 100 * time.Millisecond}
+
+	var results []SinkResult
+	ms := NewMultiSink([]*Sink[interface{}]{
+		NewSink[interface{}]("db", db, SinkRetry(2, time.Millisecond, time.Millisecond)),
+		NewSink[interface{}]("archive", archive, SinkBestEffort()),
+		NewSink[interface{}]("slow", slow, SinkBestEffort(), SinkTimeout(10*time.Millisecond)),
+	}, MultiSinkResults(func(r []SinkResult) { results = r }))
+
+	if err := ms.PutMulti([]interface{}{"a", "b"}); err != nil {
+		t.Fatalf("expected best-effort failures to be ignored, got %v", err)
+	}
+	if db.received != 2 || results[0].Err != nil || results[0].Attempts != 2 {
+		t.Fatalf("expected db delivered on 2nd attempt, got %+v", results[0])
+	}
+	if results[1].Err != errTemporary || results[1].Required {
+		t.Fatalf("expected best-effort archive failure, got %+v", results[1])
+	}
+	if results[2].Err != ErrSinkTimeout {
+		t.Fatalf("expected slow sink timeout, got %+v", results[2])
+	}
+
+	// required sink failure fails the batch without pipeline retries
+	ms = NewMultiSink([]*Sink[interface{}]{
+		NewSink[interface{}]("db", &flakyWorker{failures: 100, err: errTemporary}),
+		NewSink[interface{}]("archive", &flakyWorker{}),
+	})
+	err := ms.PutMulti([]interface{}{"a"})
+	var msErr *MultiSinkError
+	if !errors.As(err, &msErr) || !IsPermanent(err) {
+		t.Fatalf("expected permanent MultiSinkError, got %v", err)
+	}
+	if len(msErr.Results) != 2 || msErr.Results[0].Err != errTemporary || msErr.Results[1].Err != nil {
+		t.Fatalf("expected per sink results, got %+v", msErr.Results)
+	}
+	if err.Error() != "sinks failed: db: datastore blip" {
+		t.Fatalf("unexpected error message: %v", err)
+	}
+}
+
+// contextWorker blocks until its context is cancelled
+type contextWorker struct {
+	cancelled chan error
+}
+
+func (cw *contextWorker) PutMulti(events []interface{}) error {
+	return nil
+}
+
+func (cw *contextWorker) PutMultiContext(ctx context.Context, events []interface{}) error {
+	<-ctx.Done()
+	cw.cancelled <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestMultiSinkContextTimeout(t *testing.T) {
+	cw := &contextWorker{cancelled: make(chan error, 1)}
+	ms := NewMultiSink([]*Sink[interface{}]{NewSink[interface{}]("blocking", cw, SinkTimeout(10*time.Millisecond))})
+	var msErr *MultiSinkError
+	if err := ms.PutMulti([]interface{}{"a"}); !errors.As(err, &msErr) || msErr.Results[0].Err != ErrSinkTimeout {
+		t.Fatalf("expected sink timeout, got %v", err)
+	}
+	select {
+	case <-cw.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected sink context to be cancelled on timeout")
+	}
+}
+
+func TestMultiSinkCloseDuringBackoff(t *testing.T) {
+	fw := &flakyWorker{failures: 100, err: errTemporary}
+	ms := NewMultiSink([]*Sink[interface{}]{NewSink[interface{}]("db", fw, SinkRetry(3, time.Hour, time.Hour))})
+	dl := &deadLetterCollector{}
+	bp, err := NewBackpressureContext(ms, BatchMaxSize(1), Workers(1), DeadLetterSink(dl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.Add("a")
+	waitFor(t, func() bool {
+		fw.mutex.Lock()
+		defer fw.mutex.Unlock()
+		return fw.calls == 1
+	})
+	bp.Close()
+
+	stopped := make(chan struct{})
+	go func() {
+		bp.workerWg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to interrupt sink backoff")
+	}
+	if len(dl.events) != 0 {
+		t.Fatalf("expected no dead letters after Close, got %v", dl.events)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// ErrQueueFull when TryAdd can't queue the event without blocking
+	ErrQueueFull = errors.New("backpressure event queue is full")
+)
+
+// OverflowPolicy what Add does when the event queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks until there is space in the queue (default)
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the event being added
+	OverflowDropNewest
+	// OverflowDropOldest evicts the oldest queued event to make space for the new one (requires MaxEventsInQueue).
+	// If there is still no space the new event is dropped
+	OverflowDropOldest
+	// OverflowSample queues (blocking) 1 in SampleRate events and drops the rest while the queue is full
+	OverflowSample
+)
+
+// DropStats number of events dropped by each overflow policy
+type DropStats struct {
+	Newest  uint64 // dropped with OverflowDropNewest
+	Oldest  uint64 // evicted from the queue with OverflowDropOldest
+	Sampled uint64 // dropped with OverflowSample
+}
+
+// Total number of dropped events
+func (ds DropStats) Total() uint64 {
+	return ds.Newest + ds.Oldest + ds.Sampled
+}
+
+// TryAdd queues the event without blocking regardless of the Overflow policy. Returns ErrQueueFull if the queue is full
+func (rc *TypedPressureContext[T]) TryAdd(value T) error {
+	if rc == nil {
+		return ErrBackPressureInit
+	}
+	if !rc.enter() {
+		return ErrClosed
+	}
+	defer rc.addWg.Done()
+
+	rec, err := rc.admit(value)
+	if err != nil {
+		return err
+	}
+	ok, err := rc.offer(rec)
+	if !ok {
+		rc.discard(rec)
+	}
+	if err == nil && !ok {
+		return ErrQueueFull
+	}
+	return err
+}
+
+// AddWithContext blocks until the event is queued or ctx is done regardless of the Overflow policy. Returns ctx.Err() if ctx is done first
+func (rc *TypedPressureContext[T]) AddWithContext(ctx context.Context, value T) error {
+	if rc == nil {
+		return ErrBackPressureInit
+	}
+	if !rc.enter() {
+		return ErrClosed
+	}
+	defer rc.addWg.Done()
+
+	rec, err := rc.admit(value)
+	if err != nil {
+		return err
+	}
+	if err := rc.send(ctx, rec); err != nil {
+		rc.discard(rec)
+		return err
+	}
+	return nil
+}
+
+// Dropped events by overflow policy since the context was created
+func (rc *TypedPressureContext[T]) Dropped() DropStats {
+	return DropStats{
+		Newest:  atomic.LoadUint64(&rc.droppedNewest),
+		Oldest:  atomic.LoadUint64(&rc.droppedOldest),
+		Sampled: atomic.LoadUint64(&rc.droppedSampled),
+	}
+}
+
+// offer queues the event if there is space, false if queue is full
+func (rc *TypedPressureContext[T]) offer(rec record[T]) (bool, error) {
+	select {
+	case <-rc.stopChan:
+		return false, ErrClosed
+	default:
+	}
+	select {
+	case rc.inputChan <- rec:
+		atomic.AddUint64(&rc.accepted, 1)
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// send blocks until event is queued, backpressure context is stopped or ctx is done
+func (rc *TypedPressureContext[T]) send(ctx context.Context, rec record[T]) error {
+	select {
+	case rc.inputChan <- rec:
+		atomic.AddUint64(&rc.accepted, 1)
+		return nil
+	case <-rc.stopChan:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addDropOldest evicts the oldest queued event if queue is full. If there is still no space
+// (e.g. unbuffered queue or concurrent producers) the new event is dropped and counted as Newest
+func (rc *TypedPressureContext[T]) addDropOldest(rec record[T]) (bool, error) {
+	ok, err := rc.offer(rec)
+	if ok || err != nil {
+		return ok, err
+	}
+	select {
+	case oldest := <-rc.inputChan:
+		atomic.AddUint64(&rc.droppedOldest, 1)
+		rc.discard(oldest)
+	default:
+	}
+	ok, err = rc.offer(rec)
+	if err == nil && !ok {
+		atomic.AddUint64(&rc.droppedNewest, 1)
+	}
+	return ok, err
+}
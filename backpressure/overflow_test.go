@@ -0,0 +1,85 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// saturate fills the pipeline with a blocked worker: 1 batch in PutMulti, 1 in batch queue, 1 in collector and 2 in event queue
+func saturate(t *testing.T, opts ...Option) (*PressureContext, *blockingWorker) {
+	bw := &blockingWorker{release: make(chan struct{})}
+	opts = append([]Option{BatchMaxSize(1), BatchTimeMs(10000), Workers(1), MaxBatchesInQueue(1), MaxEventsInQueue(2)}, opts...)
+	bp, err := NewBackpressureContext(bw, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := bp.AddWithContext(context.Background(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bp, bw
+}
+
+func TestTryAddAndAddWithContext(t *testing.T) {
+	bp, bw := saturate(t)
+	defer bp.Close()
+	defer close(bw.release)
+
+	if err := bp.TryAdd("full"); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := bp.AddWithContext(ctx, "full"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if dropped := bp.Dropped().Total(); dropped != 0 {
+		t.Fatalf("expected no dropped events, got %v", dropped)
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   OverflowPolicy
+		adds     int
+		expected DropStats
+	}{
+		{name: "drop newest", policy: OverflowDropNewest, adds: 3, expected: DropStats{Newest: 3}},
+		{name: "drop oldest", policy: OverflowDropOldest, adds: 3, expected: DropStats{Oldest: 3}},
+		{name: "sample", policy: OverflowSample, adds: 2, expected: DropStats{Sampled: 2}},
+	}
+	for _, test := range tests {
+		bp, bw := saturate(t, Overflow(test.policy), SampleRate(3))
+		for i := 0; i < test.adds; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+		}
+		if stats := bp.Dropped(); stats != test.expected {
+			t.Fatalf("%v: expected %+v, got %+v", test.name, test.expected, stats)
+		}
+
+		close(bw.release)
+		dropped, err := bp.Shutdown(context.Background())
+		if err != nil || dropped != 0 {
+			t.Fatalf("%v: expected clean shutdown, got %v dropped, %v", test.name, dropped, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"hash/fnv"
+)
+
+// PartitionKey - events with the same key are delivered in order by one worker at a time (e.g. per device).
+// Events are spread over Partitions by hash of the key, each partition has exactly one worker and worker pool is not resized in adaptive mode.
+// T must match the event type of the PressureContext
+func PartitionKey[T any](key func(event T) string) Option {
+	return func(args *Options) {
+		args.PartitionKey = key
+	}
+}
+
+// Partitions - number of partitions (and workers) with PartitionKey. Default is Workers
+func Partitions(partitions int) Option {
+	return func(args *Options) {
+		args.Partitions = partitions
+	}
+}
+
+// partitionKeyOf key extractor from PartitionKey option
+func partitionKeyOf[T any](args *Options) (func(T) string, error) {
+	if args.PartitionKey == nil {
+		return nil, nil
+	}
+	key, ok := args.PartitionKey.(func(T) string)
+	if !ok {
+		return nil, ErrOptionType
+	}
+	return key, nil
+}
+
+// partition (or priority lane) of the event, always 0 without PartitionKey and Priority
+func (rc *TypedPressureContext[T]) partition(event T) int {
+	if rc.priority != nil {
+		return rc.lane(event)
+	}
+	if rc.partitionKey == nil || len(rc.batchChans) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(rc.partitionKey(event)))
+	return int(h.Sum32() % uint32(len(rc.batchChans)))
+}
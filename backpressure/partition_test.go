@@ -0,0 +1,101 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+type annotation struct {
+	device string
+	seq    int
+}
+
+// orderWorker records per device order and detects concurrent delivery of the same device
+type orderWorker struct {
+	mutex      sync.Mutex
+	inFlight   map[string]bool
+	received   map[string][]int
+	concurrent bool
+}
+
+func (ow *orderWorker) PutMulti(events []annotation) error {
+	devices := make(map[string]bool)
+	ow.mutex.Lock()
+	for _, ev := range events {
+		if ow.inFlight[ev.device] && !devices[ev.device] {
+			ow.concurrent = true
+		}
+		devices[ev.device] = true
+		ow.inFlight[ev.device] = true
+		ow.received[ev.device] = append(ow.received[ev.device], ev.seq)
+	}
+	ow.mutex.Unlock()
+
+	time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+
+	ow.mutex.Lock()
+	for device := range devices {
+		ow.inFlight[device] = false
+	}
+	ow.mutex.Unlock()
+	return nil
+}
+
+func TestPartitionOrdering(t *testing.T) {
+	ow := &orderWorker{inFlight: make(map[string]bool), received: make(map[string][]int)}
+	bp, err := NewPressureContext[annotation](ow, PartitionKey(func(a annotation) string { return a.device }), Partitions(4),
+		Workers(8), BatchMaxSize(5), BatchTimeMs(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp.WorkerCount() != 4 {
+		t.Fatalf("expected 1 worker per partition, got %v", bp.WorkerCount())
+	}
+
+	devices := []string{"camera-1", "camera-2", "camera-3", "camera-4", "camera-5"}
+	for i := 0; i < 500; i++ {
+		if err := bp.Add(annotation{device: devices[i%len(devices)], seq: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+
+	if ow.concurrent {
+		t.Fatal("events of the same device delivered concurrently")
+	}
+	for _, device := range devices {
+		seqs := ow.received[device]
+		if len(seqs) != 100 {
+			t.Fatalf("expected 100 events of %v, got %v", device, len(seqs))
+		}
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] < seqs[i-1] {
+				t.Fatalf("%v events out of order: %v", device, fmt.Sprint(seqs))
+			}
+		}
+	}
+
+	if _, err := NewPressureContext[annotation](ow, PartitionKey(func(s string) string { return s })); err != ErrOptionType {
+		t.Fatalf("expected ErrOptionType, got %v", err)
+	}
+}
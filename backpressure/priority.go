@@ -0,0 +1,166 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrPriorityPartitions Priority and PartitionKey can't be used together
+	ErrPriorityPartitions = errors.New("priority lanes can't be combined with partition key")
+)
+
+// Lane - batch parameters of a single priority level. Zero values default to BatchMaxSize, BatchTimeMs and MaxBatchesInQueue
+type Lane struct {
+	BatchMaxSize      int
+	BatchTimeMs       float64
+	MaxBatchesInQueue int
+}
+
+// Priority - assigns every event to one of the lanes, 0 being the highest priority (e.g. alarms before routine events).
+// Workers take batches from higher priority lanes first. Out of range priorities go to the lowest priority lane.
+// T must match the event type of the PressureContext
+func Priority[T any](priority func(event T) int, lanes ...Lane) Option {
+	return func(args *Options) {
+		args.Priority = priority
+		args.Lanes = lanes
+	}
+}
+
+// StarvationGuard - every n-th batch is taken from the lowest priority lane with queued batches,
+// so low priority events keep moving under constant high priority load. Default is 10, 0 disables the guard
+func StarvationGuard(n int) Option {
+	return func(args *Options) {
+		args.StarvationGuard = n
+	}
+}
+
+// priorityOf lane selector and lanes with defaults from Priority option
+func priorityOf[T any](args *Options) (func(T) int, []Lane, error) {
+	if args.Priority == nil {
+		return nil, nil, nil
+	}
+	priority, ok := args.Priority.(func(T) int)
+	if !ok {
+		return nil, nil, ErrOptionType
+	}
+	if args.PartitionKey != nil {
+		return nil, nil, ErrPriorityPartitions
+	}
+	lanes := append([]Lane(nil), args.Lanes...)
+	if len(lanes) == 0 {
+		lanes = []Lane{{}}
+	}
+	for i := range lanes {
+		if lanes[i].BatchMaxSize <= 0 {
+			lanes[i].BatchMaxSize = args.BatchMaxSize
+		}
+		if lanes[i].BatchTimeMs <= 0 {
+			lanes[i].BatchTimeMs = float64(args.BatchTimeMs)
+		}
+		if lanes[i].MaxBatchesInQueue <= 0 {
+			lanes[i].MaxBatchesInQueue = args.MaxBatchesInQueue
+		}
+	}
+	return priority, lanes, nil
+}
+
+// lane of the event
+func (rc *TypedPressureContext[T]) lane(event T) int {
+	return boundInt(rc.priority(event), 0, len(rc.lanes)-1)
+}
+
+// flushInterval batching ticker interval, the shortest lane batch time with priority lanes. 0 flushes by size only
+func (rc *TypedPressureContext[T]) flushInterval() time.Duration {
+	interval := rc.batchTimeMs
+	for _, l := range rc.lanes {
+		if l.BatchTimeMs > 0 && (interval <= 0 || l.BatchTimeMs < interval) {
+			interval = l.BatchTimeMs
+		}
+	}
+	if interval <= 0 {
+		return 0
+	}
+	return time.Duration(interval * float64(time.Millisecond))
+}
+
+// due true if batch of partition (or lane) p should be flushed on ticker tick
+func (rc *TypedPressureContext[T]) due(p int, eb batch[T], interval time.Duration) bool {
+	if rc.lanes == nil {
+		return true
+	}
+	laneTime := time.Duration(rc.lanes[p].BatchTimeMs * float64(time.Millisecond))
+	if laneTime <= 0 {
+		return false
+	}
+	return laneTime <= interval || rc.clock.Now().Sub(eb.opened) >= laneTime
+}
+
+// nextLaneBatch waits for a queued batch and takes it from the highest priority lane.
+// Every StarvationGuard-th batch is taken from the lowest priority lane instead
+func (rc *TypedPressureContext[T]) nextLaneBatch() (batch[T], bool) {
+	select {
+	case _, ok := <-rc.laneReady:
+		if !ok {
+			return batch[T]{}, false
+		}
+	case <-rc.retireChan:
+		return batch[T]{}, false
+	case <-rc.doneChan:
+		return batch[T]{}, false
+	}
+	lowestFirst := rc.starvationGuard > 0 && atomic.AddUint64(&rc.lanePicks, 1)%uint64(rc.starvationGuard) == 0
+	for i := range rc.batchChans {
+		l := i
+		if lowestFirst {
+			l = len(rc.batchChans) - 1 - i
+		}
+		select {
+		case eb, ok := <-rc.batchChans[l]:
+			if ok {
+				return eb, true
+			}
+		default:
+		}
+	}
+	// holding a laneReady token guarantees a queued batch, block until it's visible instead of polling
+	return rc.waitLaneBatch()
+}
+
+// waitLaneBatch blocks until any lane has a batch, false when all lanes are closed or context is closed
+func (rc *TypedPressureContext[T]) waitLaneBatch() (batch[T], bool) {
+	cases := make([]reflect.SelectCase, 0, len(rc.batchChans)+1)
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(rc.doneChan)})
+	for _, batchChan := range rc.batchChans {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(batchChan)})
+	}
+	for open := len(rc.batchChans); open > 0; {
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == 0 {
+			return batch[T]{}, false
+		}
+		if ok {
+			return value.Interface().(batch[T]), true
+		}
+		// closed lane, a nil channel case never proceeds
+		cases[chosen].Chan = reflect.Value{}
+		open--
+	}
+	return batch[T]{}, false
+}
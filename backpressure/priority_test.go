@@ -0,0 +1,160 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedWorker blocks the first PutMulti until gate is closed and records delivery order
+type gatedWorker struct {
+	gate      chan struct{}
+	mutex     sync.Mutex
+	delivered [][]string
+}
+
+func (gw *gatedWorker) PutMulti(events []string) error {
+	gw.mutex.Lock()
+	first := len(gw.delivered) == 0
+	gw.delivered = append(gw.delivered, events)
+	gw.mutex.Unlock()
+	if first {
+		<-gw.gate
+	}
+	return nil
+}
+
+func alarmPriority(event string) int {
+	if strings.HasPrefix(event, "alarm") {
+		return 0
+	}
+	return 1
+}
+
+func TestPriorityLanes(t *testing.T) {
+	tests := []struct {
+		name     string
+		guard    int
+		expected []string
+	}{
+		{"higher priority first", 0, []string{"moving-0", "alarm-1", "alarm-2", "alarm-3", "moving-1", "moving-2", "moving-3"}},
+		{"starvation guard", 3, []string{"moving-0", "alarm-1", "moving-1", "alarm-2", "alarm-3", "moving-2", "moving-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := &gatedWorker{gate: make(chan struct{})}
+			bp, err := NewPressureContext[string](gw, Priority(alarmPriority, Lane{BatchMaxSize: 1}, Lane{BatchMaxSize: 1}),
+				StarvationGuard(tt.guard), Workers(1), BatchTimeMs(10000))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// first batch blocks the only worker while the rest is queued
+			bp.Add("moving-0")
+			waitFor(t, func() bool {
+				gw.mutex.Lock()
+				defer gw.mutex.Unlock()
+				return len(gw.delivered) == 1
+			})
+			for _, ev := range []string{"moving-1", "moving-2", "moving-3", "alarm-1", "alarm-2", "alarm-3"} {
+				if err := bp.Add(ev); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitFor(t, func() bool { return bp.Collected() == 7 })
+			close(gw.gate)
+			if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+				t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+			}
+
+			var order []string
+			for _, events := range gw.delivered {
+				order = append(order, events...)
+			}
+			if !reflect.DeepEqual(order, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, order)
+			}
+		})
+	}
+}
+
+func TestLaneBatchParameters(t *testing.T) {
+	gw := &gatedWorker{gate: make(chan struct{})}
+	close(gw.gate)
+	var mutex sync.Mutex
+	triggers := make(map[string][]FlushTrigger)
+	bp, err := NewPressureContext[string](gw, Priority(alarmPriority, Lane{BatchMaxSize: 1, BatchTimeMs: 5}, Lane{BatchMaxSize: 100, BatchTimeMs: 20}),
+		OnFlush(func(trigger FlushTrigger, events int) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			key := "moving"
+			if events == 1 {
+				key = "alarm"
+			}
+			triggers[key] = append(triggers[key], trigger)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		bp.Add("moving")
+	}
+	bp.Add("alarm")
+	bp.Add("alarm")
+	time.Sleep(100 * time.Millisecond)
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !reflect.DeepEqual(triggers["alarm"], []FlushTrigger{FlushSize, FlushSize}) {
+		t.Fatalf("expected alarms flushed by size, got %v", triggers["alarm"])
+	}
+	if !reflect.DeepEqual(triggers["moving"], []FlushTrigger{FlushTimer}) {
+		t.Fatalf("expected moving events flushed by lane timer, got %v", triggers["moving"])
+	}
+
+	if _, err := NewPressureContext[string](gw, Priority(alarmPriority), PartitionKey(func(e string) string { return e })); err != ErrPriorityPartitions {
+		t.Fatalf("expected priority partitions error, got %v", err)
+	}
+}
+
+func TestPriorityLaneWaitsForBatch(t *testing.T) {
+	gw := &gatedWorker{gate: make(chan struct{})}
+	close(gw.gate)
+	bp, err := NewPressureContext[string](gw, Priority(alarmPriority, Lane{BatchMaxSize: 1}, Lane{BatchMaxSize: 1}), Workers(1), BatchTimeMs(10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// token without a visible batch, the worker waits for a lane instead of spinning
+	bp.laneReady <- struct{}{}
+	bp.Add("alarm-1")
+	waitFor(t, func() bool {
+		gw.mutex.Lock()
+		defer gw.mutex.Unlock()
+		return len(gw.delivered) == 1
+	})
+
+	// the worker holds the remaining token while lanes are closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if dropped, err := bp.Shutdown(ctx); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+}
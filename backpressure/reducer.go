@@ -0,0 +1,86 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"sync/atomic"
+)
+
+// Reducer - runs on every collected batch before PutMulti, e.g. to merge or deduplicate events (see MergeByKey and Dedupe).
+// T must match the event type of the PressureContext
+func Reducer[T any](reduce func(events []T) []T) Option {
+	return func(args *Options) {
+		args.Reducer = reduce
+	}
+}
+
+// reducerOf reducer from Reducer option
+func reducerOf[T any](args *Options) (func([]T) []T, error) {
+	if args.Reducer == nil {
+		return nil, nil
+	}
+	reduce, ok := args.Reducer.(func([]T) []T)
+	if !ok {
+		return nil, ErrOptionType
+	}
+	return reduce, nil
+}
+
+// Collapsed number of events removed by Reducer since the context was created
+func (rc *TypedPressureContext[T]) Collapsed() uint64 {
+	return atomic.LoadUint64(&rc.collapsed)
+}
+
+// reduce batch events, false if nothing is left to deliver (spooled events are acknowledged)
+func (rc *TypedPressureContext[T]) reduce(eb *batch[T]) bool {
+	if rc.reducer == nil {
+		return true
+	}
+	before := len(eb.events)
+	eb.events = rc.reducer(eb.events)
+	if collapsed := before - len(eb.events); collapsed > 0 {
+		atomic.AddUint64(&rc.collapsed, uint64(collapsed))
+	}
+	if len(eb.events) == 0 {
+		rc.acknowledge(*eb)
+		return false
+	}
+	return true
+}
+
+// MergeByKey reducer merging events with the same key (e.g. ObjectTrackingID) in order of first occurrence
+func MergeByKey[T any, K comparable](key func(event T) K, merge func(merged, event T) T) func(events []T) []T {
+	return func(events []T) []T {
+		index := make(map[K]int, len(events))
+		merged := make([]T, 0, len(events))
+		for _, ev := range events {
+			k := key(ev)
+			if i, ok := index[k]; ok {
+				merged[i] = merge(merged[i], ev)
+				continue
+			}
+			index[k] = len(merged)
+			merged = append(merged, ev)
+		}
+		return merged
+	}
+}
+
+// Dedupe reducer keeping only the first event with the same key (e.g. payload hash)
+func Dedupe[T any, K comparable](key func(event T) K) func(events []T) []T {
+	return MergeByKey(key, func(first, _ T) T {
+		return first
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type detection struct {
+	trackingID string
+	count      int
+}
+
+type detectionWorker struct {
+	mutex    sync.Mutex
+	received []detection
+}
+
+func (dw *detectionWorker) PutMulti(events []detection) error {
+	dw.mutex.Lock()
+	dw.received = append(dw.received, events...)
+	dw.mutex.Unlock()
+	return nil
+}
+
+func TestMergeByKeyAndDedupe(t *testing.T) {
+	events := []detection{{"a", 1}, {"b", 1}, {"a", 2}, {"c", 1}, {"b", 3}}
+
+	merged := MergeByKey(func(d detection) string { return d.trackingID }, func(m, d detection) detection {
+		m.count += d.count
+		return m
+	})(events)
+	expected := []detection{{"a", 3}, {"b", 4}, {"c", 1}}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("expected %v, got %v", expected, merged)
+	}
+
+	deduped := Dedupe(func(d detection) string { return d.trackingID })(events)
+	expected = []detection{{"a", 1}, {"b", 1}, {"c", 1}}
+	if !reflect.DeepEqual(deduped, expected) {
+		t.Fatalf("expected %v, got %v", expected, deduped)
+	}
+}
+
+func TestReducer(t *testing.T) {
+	dw := &detectionWorker{}
+	reduce := MergeByKey(func(d detection) string { return d.trackingID }, func(m, d detection) detection {
+		m.count += d.count
+		return m
+	})
+	bp, err := NewPressureContext[detection](dw, Reducer(reduce), BatchMaxSize(10), BatchTimeMs(5), Workers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := bp.Add(detection{trackingID: []string{"a", "b"}[i%2], count: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+
+	total := 0
+	for _, d := range dw.received {
+		total += d.count
+	}
+	if total != 100 {
+		t.Fatalf("expected merged counts to add up to 100, got %v", total)
+	}
+	if bp.Collapsed() != uint64(100-len(dw.received)) {
+		t.Fatalf("expected %v collapsed, got %v", 100-len(dw.received), bp.Collapsed())
+	}
+
+	// reducer of a different event type
+	if _, err := NewPressureContext[detection](dw, Reducer(func(events []int) []int { return events })); err != ErrOptionType {
+		t.Fatalf("expected option type error, got %v", err)
+	}
+}
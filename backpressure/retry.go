@@ -0,0 +1,68 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// permanentError marks PutMulti error as non retryable
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps PutMulti error to skip retries (e.g. invalid payload). The batch goes straight to the dead letter sink
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent true if error was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var perr *permanentError
+	return errors.As(err, &perr)
+}
+
+// RetryClassifier decides if PutMulti error is retryable
+type RetryClassifier func(err error) bool
+
+// DefaultRetryClassifier all errors are retryable except the ones wrapped with Permanent
+func DefaultRetryClassifier(err error) bool {
+	return !IsPermanent(err)
+}
+
+// backoff exponential backoff with full jitter, attempt starts with 1
+func backoff(attempt int, minBackoff, maxBackoff time.Duration) time.Duration {
+	wait := minBackoff << uint(attempt-1)
+	if wait > maxBackoff || wait <= 0 {
+		wait = maxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(wait)) + 1)
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTemporary = errors.New("datastore blip")
+
+// flakyWorker fails first N PutMulti calls
+type flakyWorker struct {
+	mutex    sync.Mutex
+	failures int
+	err      error
+	calls    int
+	received int
+}
+
+func (fw *flakyWorker) PutMulti(events []interface{}) error {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.calls++
+	if fw.calls <= fw.failures {
+		return fw.err
+	}
+	fw.received += len(events)
+	return nil
+}
+
+type deadLetterCollector struct {
+	mutex  sync.Mutex
+	events []interface{}
+	errs   []error
+	done   chan struct{}
+}
+
+func (dl *deadLetterCollector) PutDeadLetter(events []interface{}, err error) {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	dl.events = append(dl.events, events...)
+	dl.errs = append(dl.errs, err)
+	if dl.done != nil {
+		close(dl.done)
+		dl.done = nil
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetryUntilDelivered(t *testing.T) {
+	fw := &flakyWorker{failures: 2, err: errTemporary}
+	dl := &deadLetterCollector{}
+	bp, err := NewBackpressureContext(fw, BatchMaxSize(3), BatchTimeMs(10), Workers(1), Retry(3, time.Millisecond, 5*time.Millisecond), DeadLetterSink(dl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bp.Close()
+
+	for i := 0; i < 3; i++ {
+		bp.Add(i)
+	}
+	waitFor(t, func() bool {
+		fw.mutex.Lock()
+		defer fw.mutex.Unlock()
+		return fw.received == 3
+	})
+	if fw.calls != 3 || len(dl.events) != 0 {
+		t.Fatalf("expected 3 calls and no dead letters, got %v calls, %v dead letters", fw.calls, len(dl.events))
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		expectedCalls int
+	}{
+		{name: "exhausted", err: errTemporary, expectedCalls: 3},
+		{name: "permanent", err: Permanent(errTemporary), expectedCalls: 1},
+	}
+	for _, test := range tests {
+		fw := &flakyWorker{failures: 100, err: test.err}
+		done := make(chan struct{})
+		dl := &deadLetterCollector{done: done}
+		bp, err := NewBackpressureContext(fw, BatchMaxSize(2), BatchTimeMs(10), Workers(1), Retry(3, time.Millisecond, 5*time.Millisecond), DeadLetterSink(dl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bp.Add("a")
+		bp.Add("b")
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: expected batch in dead letter sink", test.name)
+		}
+		bp.Close()
+
+		fw.mutex.Lock()
+		calls := fw.calls
+		fw.mutex.Unlock()
+		dl.mutex.Lock()
+		if calls != test.expectedCalls || len(dl.events) != 2 || !errors.Is(dl.errs[0], errTemporary) {
+			t.Fatalf("%v: expected %v calls and 2 dead letters, got %v calls and %v dead letters", test.name, test.expectedCalls, calls, len(dl.events))
+		}
+		dl.mutex.Unlock()
+	}
+}
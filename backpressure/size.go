@@ -0,0 +1,105 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// ErrSizeEstimator when BatchMaxBytes is set without SizeEstimator for events other than []byte and string
+	ErrSizeEstimator = errors.New("batch max bytes requires size estimator for this event type")
+)
+
+// BatchMaxBytes closes a batch once the estimated size of its events reaches maxBytes (or BatchMaxSize events, whichever comes first).
+// Size is estimated with SizeEstimator
+func BatchMaxBytes(maxBytes int) Option {
+	return func(args *Options) {
+		args.BatchMaxBytes = maxBytes
+	}
+}
+
+// SizeEstimator - estimated size of an event in bytes for BatchMaxBytes. It's called for every event on the batching goroutine,
+// so it should be cheap (e.g. sum of payload field lengths rather than encoding the event). Defaults to length of []byte
+// and string events, other event types (including interface{}) require it. T must match the event type of the PressureContext
+func SizeEstimator[T any](sizeOf func(event T) int) Option {
+	return func(args *Options) {
+		args.SizeEstimator = sizeOf
+	}
+}
+
+// OversizeHandler - receives single events larger than BatchMaxBytes instead of PutMulti (e.g. to upload them separately).
+// Called from the batching goroutine, so it should return quickly.
+// Without the handler oversized events are delivered alone in a batch of their own
+func OversizeHandler[T any](handler func(event T, size int)) Option {
+	return func(args *Options) {
+		args.OversizeHandler = handler
+	}
+}
+
+// sizeEstimatorOf size estimator and oversize handler from options
+func sizeEstimatorOf[T any](args *Options) (func(T) int, func(T, int), error) {
+	var handler func(T, int)
+	if args.OversizeHandler != nil {
+		h, ok := args.OversizeHandler.(func(T, int))
+		if !ok {
+			return nil, nil, ErrOptionType
+		}
+		handler = h
+	}
+	if args.SizeEstimator != nil {
+		sizeOf, ok := args.SizeEstimator.(func(T) int)
+		if !ok {
+			return nil, nil, ErrOptionType
+		}
+		return sizeOf, handler, nil
+	}
+	if args.BatchMaxBytes <= 0 {
+		return nil, handler, nil
+	}
+	var zero T
+	switch interface{}(zero).(type) {
+	case []byte, string:
+		return func(event T) int {
+			switch ev := interface{}(event).(type) {
+			case []byte:
+				return len(ev)
+			case string:
+				return len(ev)
+			}
+			return 0
+		}, handler, nil
+	}
+	return nil, nil, ErrSizeEstimator
+}
+
+// Oversized number of events routed to OversizeHandler since the context was created
+func (rc *TypedPressureContext[T]) Oversized() uint64 {
+	return atomic.LoadUint64(&rc.oversized)
+}
+
+// oversize routes event to the oversize handler, false if there's no handler
+func (rc *TypedPressureContext[T]) oversize(rec record[T], size int) bool {
+	if rc.oversizeHandler == nil {
+		return false
+	}
+	rc.oversizeHandler(rec.event, size)
+	atomic.AddUint64(&rc.oversized, 1)
+	if rc.spool != nil && rec.seq > 0 {
+		rc.ackSpool([]uint64{rec.seq})
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// bytesWorker records sizes of delivered batches
+type bytesWorker struct {
+	mutex   sync.Mutex
+	batches [][]int
+}
+
+func (bw *bytesWorker) PutMulti(events [][]byte) error {
+	sizes := make([]int, 0, len(events))
+	for _, ev := range events {
+		sizes = append(sizes, len(ev))
+	}
+	bw.mutex.Lock()
+	bw.batches = append(bw.batches, sizes)
+	bw.mutex.Unlock()
+	return nil
+}
+
+func TestBatchMaxBytes(t *testing.T) {
+	bw := &bytesWorker{}
+	bp, err := NewPressureContext[[]byte](bw, BatchMaxBytes(1000), BatchMaxSize(100), BatchTimeMs(1000), Workers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := bp.Add(make([]byte, 300)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// oversized event without handler is delivered alone
+	if err := bp.Add(make([]byte, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+
+	events := 0
+	for _, sizes := range bw.batches {
+		total := 0
+		for _, size := range sizes {
+			total += size
+		}
+		if len(sizes) > 1 && total > 1000 {
+			t.Fatalf("batch of %v bytes exceeds BatchMaxBytes: %v", total, sizes)
+		}
+		if total == 5000 && len(sizes) != 1 {
+			t.Fatalf("expected oversized event alone, got %v", sizes)
+		}
+		events += len(sizes)
+	}
+	if events != 21 {
+		t.Fatalf("expected 21 events delivered, got %v", events)
+	}
+}
+
+func TestOversizeHandler(t *testing.T) {
+	bw := &bytesWorker{}
+	var mutex sync.Mutex
+	var oversized []int
+	bp, err := NewPressureContext[[]byte](bw, BatchMaxBytes(1000), BatchTimeMs(5),
+		OversizeHandler(func(event []byte, size int) {
+			mutex.Lock()
+			oversized = append(oversized, size)
+			mutex.Unlock()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{100, 2000, 100, 3000} {
+		if err := bp.Add(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(oversized) != 2 || oversized[0] != 2000 || oversized[1] != 3000 || bp.Oversized() != 2 {
+		t.Fatalf("expected 2 oversized events, got %v", oversized)
+	}
+	for _, sizes := range bw.batches {
+		for _, size := range sizes {
+			if size != 100 {
+				t.Fatalf("oversized event delivered to PutMulti: %v", sizes)
+			}
+		}
+	}
+
+	// estimator of a different event type
+	if _, err := NewPressureContext[[]byte](bw, BatchMaxBytes(10), SizeEstimator(func(s string) int { return len(s) })); err != ErrOptionType {
+		t.Fatalf("expected option type error, got %v", err)
+	}
+
+	// other event types need an explicit estimator
+	tw := &typedWorker{}
+	if _, err := NewPressureContext[event](tw, BatchMaxBytes(10)); err != ErrSizeEstimator {
+		t.Fatalf("expected size estimator error, got %v", err)
+	}
+	if _, err := NewBackpressureContext(&batchWorker{}, BatchMaxBytes(10)); err != ErrSizeEstimator {
+		t.Fatalf("expected size estimator error for untyped events, got %v", err)
+	}
+	typed, err := NewPressureContext[event](tw, BatchMaxBytes(10), SizeEstimator(func(e event) int { return len(e.name) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed.Close()
+}
@@ -0,0 +1,464 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSpoolFull when the spool reached SpoolMaxSize (unacknowledged events are not removed)
+	ErrSpoolFull = errors.New("backpressure spool is full")
+)
+
+// SyncPolicy when spool segment files are fsynced
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs every event before Add returns (default)
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs at most once per SpoolSyncInterval (events of the last interval can be lost on power failure)
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+const (
+	spoolSegmentExt    = ".wal"
+	spoolAckFile       = "ack"
+	spoolRecordHeader  = 16 // sequence number (8), payload length (4), payload crc32 (4)
+	spoolMaxRecordSize = 1 << 30
+)
+
+// spoolRecord unacknowledged event read from the spool on restart
+type spoolRecord struct {
+	seq     uint64
+	payload []byte
+}
+
+// spoolSegment a segment file with records from first to last sequence number
+type spoolSegment struct {
+	path  string
+	first uint64
+	last  uint64
+	size  int64
+}
+
+// spool write-ahead log of events. Events are appended to segment files and acknowledged by sequence number.
+// All events up to the ack watermark are persisted in the ack file, segments below watermark are removed
+type spool struct {
+	dir          string
+	segmentSize  int64
+	maxSize      int64
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+
+	mutex     sync.Mutex
+	closed    bool
+	active    *os.File
+	segments  []*spoolSegment // last one is the active segment
+	totalSize int64
+	nextSeq   uint64
+	acked     uint64              // all sequence numbers up to acked are acknowledged
+	ackedOver map[uint64]struct{} // acknowledged sequence numbers above watermark
+	lastSync  time.Time
+}
+
+// openSpool opens (or creates) spool in dir and returns events that were not acknowledged before restart
+func openSpool(dir string, segmentSize, maxSize int64, syncPolicy SyncPolicy, syncInterval time.Duration) (*spool, []spoolRecord, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	s := &spool{
+		dir:          dir,
+		segmentSize:  segmentSize,
+		maxSize:      maxSize,
+		syncPolicy:   syncPolicy,
+		syncInterval: syncInterval,
+		ackedOver:    make(map[uint64]struct{}),
+		lastSync:     time.Now(),
+	}
+
+	ack, err := ioutil.ReadFile(filepath.Join(dir, spoolAckFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if len(ack) > 0 {
+		if s.acked, err = strconv.ParseUint(strings.TrimSpace(string(ack)), 10, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid spool ack file: %w", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(files)
+
+	lastSeq := s.acked
+	pending := make([]spoolRecord, 0)
+	for _, path := range files {
+		segment, records, err := s.readSegment(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if segment.size == 0 || segment.last <= s.acked {
+			if err := os.Remove(path); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if segment.last > lastSeq {
+			lastSeq = segment.last
+		}
+		s.segments = append(s.segments, segment)
+		s.totalSize += segment.size
+		pending = append(pending, records...)
+	}
+	s.nextSeq = lastSeq + 1
+	return s, pending, nil
+}
+
+// readSegment reads records of a segment above ack watermark. Torn or corrupted tail (e.g. power loss during write) is truncated
+func (s *spool) readSegment(path string) (*spoolSegment, []spoolRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	segment := &spoolSegment{path: path}
+	records := make([]spoolRecord, 0)
+	offset := 0
+	for offset+spoolRecordHeader <= len(data) {
+		seq := binary.BigEndian.Uint64(data[offset:])
+		length := int(binary.BigEndian.Uint32(data[offset+8:]))
+		checksum := binary.BigEndian.Uint32(data[offset+12:])
+		end := offset + spoolRecordHeader + length
+		if end > len(data) || crc32.ChecksumIEEE(data[offset+spoolRecordHeader:end]) != checksum {
+			break
+		}
+		if segment.first == 0 {
+			segment.first = seq
+		}
+		segment.last = seq
+		if seq > s.acked {
+			records = append(records, spoolRecord{seq: seq, payload: data[offset+spoolRecordHeader : end]})
+		}
+		offset = end
+	}
+	if offset < len(data) {
+		if err := os.Truncate(path, int64(offset)); err != nil {
+			return nil, nil, err
+		}
+	}
+	segment.size = int64(offset)
+	return segment, records, nil
+}
+
+// append event payload and return its sequence number
+func (s *spool) append(payload []byte) (uint64, error) {
+	if len(payload) > spoolMaxRecordSize {
+		return 0, ErrSpoolFull
+	}
+	size := int64(spoolRecordHeader + len(payload))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	if s.maxSize > 0 && s.totalSize+size > s.maxSize {
+		return 0, ErrSpoolFull
+	}
+	if s.active == nil || s.current().size+size > s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := s.nextSeq
+	record := make([]byte, size)
+	binary.BigEndian.PutUint64(record, seq)
+	binary.BigEndian.PutUint32(record[8:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[12:], crc32.ChecksumIEEE(payload))
+	copy(record[spoolRecordHeader:], payload)
+
+	segment := s.current()
+	if _, err := s.active.Write(record); err != nil {
+		// drop partially written record, otherwise the following records would be unreadable
+		s.active.Truncate(segment.size)
+		s.active.Seek(segment.size, io.SeekStart)
+		return 0, err
+	}
+	s.nextSeq++
+	if segment.first == 0 {
+		segment.first = seq
+	}
+	segment.last = seq
+	segment.size += size
+	s.totalSize += size
+
+	if err := s.maybeSync(); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+func (s *spool) current() *spoolSegment {
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate closes active segment and starts a new one named by the next sequence number
+func (s *spool) rotate() error {
+	if s.active != nil {
+		if s.syncPolicy != SyncNever {
+			if err := s.active.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+		s.active = nil
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.nextSeq, spoolSegmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.segments = append(s.segments, &spoolSegment{path: path})
+	return nil
+}
+
+func (s *spool) maybeSync() error {
+	switch s.syncPolicy {
+	case SyncAlways:
+		return s.active.Sync()
+	case SyncInterval:
+		if time.Since(s.lastSync) >= s.syncInterval {
+			s.lastSync = time.Now()
+			return s.active.Sync()
+		}
+	}
+	return nil
+}
+
+// ack acknowledges events by sequence number (0 is ignored). Moves watermark over consecutive acknowledged events
+// and removes segments below it
+func (s *spool) ack(seqs []uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	for _, seq := range seqs {
+		if seq > s.acked {
+			s.ackedOver[seq] = struct{}{}
+		}
+	}
+	watermark := s.acked
+	for {
+		if _, ok := s.ackedOver[watermark+1]; !ok {
+			break
+		}
+		delete(s.ackedOver, watermark+1)
+		watermark++
+	}
+	if watermark == s.acked {
+		return nil
+	}
+	s.acked = watermark
+	if err := s.writeAck(); err != nil {
+		return err
+	}
+
+	// remove acknowledged segments except the active one
+	remaining := s.segments[:0]
+	for i, segment := range s.segments {
+		if i < len(s.segments)-1 && segment.last <= s.acked {
+			if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			s.totalSize -= segment.size
+			continue
+		}
+		remaining = append(remaining, segment)
+	}
+	s.segments = remaining
+	return nil
+}
+
+// writeAck atomically replaces ack file with the current watermark
+func (s *spool) writeAck() error {
+	path := filepath.Join(s.dir, spoolAckFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.FormatUint(s.acked, 10)); err != nil {
+		f.Close()
+		return err
+	}
+	if s.syncPolicy == SyncAlways {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// size of the unacknowledged segments in bytes
+func (s *spool) size() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.totalSize
+}
+
+// close syncs and closes the active segment
+func (s *spool) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.active == nil {
+		return nil
+	}
+	if s.syncPolicy != SyncNever {
+		if err := s.active.Sync(); err != nil {
+			s.active.Close()
+			return err
+		}
+	}
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	if segment := s.current(); segment.size == 0 {
+		s.segments = s.segments[:len(s.segments)-1]
+		return os.Remove(segment.path)
+	}
+	return nil
+}
+
+// spoolCodec serializes events of type T in the spool
+type spoolCodec[T any] struct {
+	encode func(event T) ([]byte, error)
+	decode func(data []byte) (T, error)
+}
+
+// spoolCodecOf codec from SpoolCodec option or JSON
+func spoolCodecOf[T any](args *Options) (spoolCodec[T], error) {
+	if args.SpoolCodec != nil {
+		codec, ok := args.SpoolCodec.(spoolCodec[T])
+		if !ok {
+			return codec, ErrOptionType
+		}
+		return codec, nil
+	}
+	return spoolCodec[T]{
+		encode: func(event T) ([]byte, error) {
+			return json.Marshal(event)
+		},
+		decode: func(data []byte) (T, error) {
+			var event T
+			err := json.Unmarshal(data, &event)
+			return event, err
+		},
+	}, nil
+}
+
+// admit appends event to the spool (if enabled) before it's queued
+func (rc *TypedPressureContext[T]) admit(value T) (record[T], error) {
+	rec := record[T]{event: value}
+	if rc.spool == nil {
+		return rec, nil
+	}
+	payload, err := rc.codec.encode(value)
+	if err != nil {
+		return rec, err
+	}
+	rec.seq, err = rc.spool.append(payload)
+	return rec, err
+}
+
+// discard acknowledges spooled event that was not queued or was dropped by overflow policy
+func (rc *TypedPressureContext[T]) discard(rec record[T]) {
+	if rc.spool != nil && rec.seq > 0 {
+		rc.ackSpool([]uint64{rec.seq})
+	}
+}
+
+// acknowledge spooled events of delivered (or dead lettered) batch
+func (rc *TypedPressureContext[T]) acknowledge(eb batch[T]) {
+	if rc.spool != nil && len(eb.seqs) > 0 {
+		rc.ackSpool(eb.seqs)
+	}
+}
+
+func (rc *TypedPressureContext[T]) ackSpool(seqs []uint64) {
+	if err := rc.spool.ack(seqs); err != nil && err != ErrClosed && rc.log != nil {
+		rc.log.Error("failed to acknowledge spooled events", err)
+	}
+}
+
+// replay queues events that were not acknowledged before restart. Events that can't be decoded are logged and acknowledged
+func (rc *TypedPressureContext[T]) replay(pending []spoolRecord) {
+	defer rc.addWg.Done()
+	if rc.log != nil {
+		rc.log.Info("replaying unacknowledged spooled events", len(pending))
+	}
+	for _, sr := range pending {
+		event, err := rc.codec.decode(sr.payload)
+		if err != nil {
+			if rc.log != nil {
+				rc.log.Error("failed to decode spooled event", sr.seq, err)
+			}
+			rc.ackSpool([]uint64{sr.seq})
+			continue
+		}
+		if err := rc.send(context.Background(), record[T]{event: event, seq: sr.seq}); err != nil {
+			return
+		}
+	}
+}
+
+func (rc *TypedPressureContext[T]) closeSpool() {
+	if rc.spool == nil {
+		return
+	}
+	if err := rc.spool.close(); err != nil && rc.log != nil {
+		rc.log.Error("failed to close spool", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spoolEvent struct {
+	Name string
+}
+
+type spoolWorker struct {
+	mutex   sync.Mutex
+	release chan struct{}
+	events  []spoolEvent
+}
+
+func (sw *spoolWorker) PutMulti(events []spoolEvent) error {
+	if sw.release != nil {
+		<-sw.release
+	}
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	sw.events = append(sw.events, events...)
+	return nil
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// power loss: PutMulti never completes
+	blocked := &spoolWorker{release: make(chan struct{})}
+	bp, err := NewPressureContext[spoolEvent](blocked, Spool(dir), BatchMaxSize(2), BatchTimeMs(10), Workers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := bp.Add(spoolEvent{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bp.Close()
+	close(blocked.release)
+
+	sw := &spoolWorker{}
+	bp, err = NewPressureContext[spoolEvent](sw, Spool(dir), BatchMaxSize(2), BatchTimeMs(10), Workers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		sw.mutex.Lock()
+		defer sw.mutex.Unlock()
+		return len(sw.events) == 5
+	})
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+	if sw.events[0].Name != "a" || sw.events[4].Name != "e" {
+		t.Fatalf("expected replayed events in order, got %v", sw.events)
+	}
+
+	// everything acknowledged, nothing to replay
+	_, pending, err := openSpool(dir, 1<<20, 0, SyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("expected no pending events, got %v", len(pending))
+	}
+}
+
+func TestSpoolSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// each segment holds 2 records of 20 bytes
+	s, _, err := openSpool(dir, 40, 100, SyncInterval, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := s.append([]byte("abcd")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.append([]byte("abcd")); err != ErrSpoolFull {
+		t.Fatalf("expected ErrSpoolFull, got %v", err)
+	}
+	if len(s.segments) != 3 {
+		t.Fatalf("expected 3 segments, got %v", len(s.segments))
+	}
+
+	// acknowledged out of order: watermark moves only over consecutive events
+	if err := s.ack([]uint64{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if s.acked != 0 || s.size() != 100 {
+		t.Fatalf("expected watermark 0 and 100 bytes, got %v and %v", s.acked, s.size())
+	}
+	if err := s.ack([]uint64{1}); err != nil {
+		t.Fatal(err)
+	}
+	if s.acked != 3 || s.size() != 60 || len(s.segments) != 2 {
+		t.Fatalf("expected watermark 3, 60 bytes in 2 segments, got %v, %v in %v", s.acked, s.size(), len(s.segments))
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// torn write at the end of the last segment
+	last := filepath.Join(dir, "00000000000000000005.wal")
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 6, 0, 0})
+	f.Close()
+
+	s, pending, err := openSpool(dir, 40, 100, SyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if len(pending) != 2 || pending[0].seq != 4 || pending[1].seq != 5 || string(pending[1].payload) != "abcd" {
+		t.Fatalf("expected events 4 and 5 pending, got %v", pending)
+	}
+	if info, _ := os.Stat(last); info.Size() != 20 {
+		t.Fatalf("expected torn record truncated, got %v bytes", info.Size())
+	}
+	if seq, err := s.append([]byte("abcd")); err != nil || seq != 6 {
+		t.Fatalf("expected next sequence number 6, got %v, %v", seq, err)
+	}
+}
+
+type failingSpoolWorker struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (fw *failingSpoolWorker) PutMulti(events []spoolEvent) error {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.calls++
+	return errTemporary
+}
+
+func TestSpoolCloseDuringBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fw := &failingSpoolWorker{}
+	var mutex sync.Mutex
+	var deadLettered []spoolEvent
+	bp, err := NewPressureContext[spoolEvent](fw, Spool(dir), BatchMaxSize(2), BatchTimeMs(10), Workers(1), Retry(3, time.Hour, time.Hour),
+		DeadLetterFunc(func(events []spoolEvent, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			deadLettered = append(deadLettered, events...)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.Add(spoolEvent{Name: "a"})
+	bp.Add(spoolEvent{Name: "b"})
+	waitFor(t, func() bool {
+		fw.mutex.Lock()
+		defer fw.mutex.Unlock()
+		return fw.calls == 1
+	})
+	bp.Close()
+	bp.workerWg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(deadLettered) != 0 {
+		t.Fatalf("expected no dead letters after Close, got %v", deadLettered)
+	}
+	_, pending, err := openSpool(dir, 1<<20, 0, SyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("expected 2 events to replay, got %v", len(pending))
+	}
+}
+
+type firstFailingWorker struct {
+	mutex  sync.Mutex
+	calls  int
+	events []spoolEvent
+}
+
+func (fw *firstFailingWorker) PutMulti(events []spoolEvent) error {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.calls++
+	if fw.calls == 1 {
+		return errTemporary
+	}
+	fw.events = append(fw.events, events...)
+	return nil
+}
+
+func TestSpoolFailedBatchAcknowledged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fw := &firstFailingWorker{}
+	bp, err := NewPressureContext[spoolEvent](fw, Spool(dir), SpoolSegmentSize(200), SpoolMaxSize(2000), SpoolSync(SyncNever, 0),
+		BatchMaxSize(1), Workers(1), Retry(1, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bp.Close()
+	// the failed first batch must not pin the spool watermark
+	for i := 0; i < 200; i++ {
+		if err := bp.Add(spoolEvent{Name: "event"}); err != nil {
+			t.Fatalf("add %v: %v", i, err)
+		}
+		waitFor(t, func() bool {
+			fw.mutex.Lock()
+			defer fw.mutex.Unlock()
+			return fw.calls == i+1
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+	"golang.org/x/time/rate"
+)
+
+// CircuitState state of the circuit breaker in front of PutMulti
+type CircuitState int
+
+const (
+	// CircuitClosed batches are delivered
+	CircuitClosed CircuitState = iota
+	// CircuitOpen delivery is paused after consecutive failures
+	CircuitOpen
+	// CircuitHalfOpen a single probe delivery decides whether circuit closes or opens again
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// RateLimit - token bucket limits of PutMulti calls per second and events per second delivered downstream (0 is unlimited).
+// Retries count against the limits
+func RateLimit(batchesPerSecond, eventsPerSecond float64) Option {
+	return func(args *Options) {
+		args.BatchesPerSecond = batchesPerSecond
+		args.EventsPerSecond = eventsPerSecond
+	}
+}
+
+// MaxInFlight - maximum number of concurrent PutMulti calls regardless of the number of workers. Default 0 (unlimited)
+func MaxInFlight(maxInFlight int) Option {
+	return func(args *Options) {
+		args.MaxInFlight = maxInFlight
+	}
+}
+
+// CircuitBreaker - pauses delivery for timeout after threshold consecutive retryable PutMulti failures,
+// then a single probe call decides whether delivery resumes or pauses again
+func CircuitBreaker(threshold int, timeout time.Duration) Option {
+	return func(args *Options) {
+		args.BreakerThreshold = threshold
+		args.BreakerTimeout = timeout
+	}
+}
+
+func (rc *TypedPressureContext[T]) initThrottling(args *Options) {
+	if args.BatchesPerSecond > 0 {
+		rc.batchLimiter = rate.NewLimiter(rate.Limit(args.BatchesPerSecond), int(math.Max(1, math.Ceil(args.BatchesPerSecond))))
+	}
+	if args.EventsPerSecond > 0 {
+		burst := int(math.Max(float64(args.BatchMaxSize), math.Ceil(args.EventsPerSecond)))
+		rc.eventLimiter = rate.NewLimiter(rate.Limit(args.EventsPerSecond), burst)
+	}
+	if args.MaxInFlight > 0 {
+		rc.inFlight = make(chan struct{}, args.MaxInFlight)
+	}
+	if args.BreakerThreshold > 0 {
+		rc.breaker = &circuitBreaker{threshold: args.BreakerThreshold, timeout: args.BreakerTimeout, log: rc.log}
+	}
+}
+
+// CircuitState current state of the circuit breaker (always closed without CircuitBreaker)
+func (rc *TypedPressureContext[T]) CircuitState() CircuitState {
+	if rc.breaker == nil {
+		return CircuitClosed
+	}
+	return rc.breaker.current()
+}
+
+// throttle waits until circuit breaker, rate limits and in-flight limit allow PutMulti call of size events.
+// Returns ErrClosed if context was closed meanwhile
+func (rc *TypedPressureContext[T]) throttle(size int) error {
+	if rc.breaker != nil {
+		for {
+			wait := rc.breaker.acquire(rc.clock.Now())
+			if wait == 0 {
+				break
+			}
+			if !rc.sleep(wait) {
+				return ErrClosed
+			}
+		}
+	}
+	if rc.batchLimiter != nil && !rc.sleep(rc.reserve(rc.batchLimiter, 1)) {
+		return ErrClosed
+	}
+	if rc.eventLimiter != nil && !rc.sleep(rc.reserve(rc.eventLimiter, size)) {
+		return ErrClosed
+	}
+	if rc.inFlight != nil {
+		select {
+		case rc.inFlight <- struct{}{}:
+		case <-rc.doneChan:
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// release in-flight slot and record PutMulti result in the circuit breaker
+func (rc *TypedPressureContext[T]) release(err error) {
+	if rc.inFlight != nil {
+		<-rc.inFlight
+	}
+	if rc.breaker != nil {
+		rc.breaker.result(rc.clock.Now(), err == nil || !rc.retryClassifier(err))
+	}
+}
+
+// reserve n tokens (bounded by burst) and return how long to wait for them
+func (rc *TypedPressureContext[T]) reserve(limiter *rate.Limiter, n int) time.Duration {
+	if n > limiter.Burst() {
+		n = limiter.Burst()
+	}
+	now := rc.clock.Now()
+	return limiter.ReserveN(now, n).DelayFrom(now)
+}
+
+// sleep for d, false if context was closed meanwhile
+func (rc *TypedPressureContext[T]) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := rc.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-rc.doneChan:
+		return false
+	}
+}
+
+// circuitBreaker opens after threshold consecutive failures, allows a single probe after timeout
+type circuitBreaker struct {
+	threshold int
+	timeout   time.Duration
+	log       mclog.Logger
+
+	mutex    sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+// acquire returns how long to wait before trying again, 0 if call may proceed
+func (cb *circuitBreaker) acquire(now time.Time) time.Duration {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	switch cb.state {
+	case CircuitOpen:
+		if remaining := cb.openedAt.Add(cb.timeout).Sub(now); remaining > 0 {
+			return remaining
+		}
+		// this call is the probe
+		cb.state = CircuitHalfOpen
+		return 0
+	case CircuitHalfOpen:
+		// wait for the probe result
+		return cb.probeWait()
+	}
+	return 0
+}
+
+func (cb *circuitBreaker) probeWait() time.Duration {
+	wait := cb.timeout / 10
+	if wait < time.Millisecond {
+		wait = time.Millisecond
+	}
+	return wait
+}
+
+// result of the call, ok if succeeded or failed with non retryable error
+func (cb *circuitBreaker) result(now time.Time, ok bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if ok {
+		if cb.state != CircuitClosed && cb.log != nil {
+			cb.log.Warn("circuit breaker closed, resuming delivery")
+		}
+		cb.state = CircuitClosed
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.state == CircuitHalfOpen || (cb.state == CircuitClosed && cb.failures >= cb.threshold) {
+		if cb.log != nil {
+			cb.log.Warn("circuit breaker open, pausing delivery", cb.timeout, "consecutive failures", cb.failures)
+		}
+		cb.state = CircuitOpen
+		cb.openedAt = now
+	}
+}
+
+func (cb *circuitBreaker) current() CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state
+}
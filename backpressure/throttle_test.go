@@ -0,0 +1,98 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backpressure
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyWorker records maximum number of concurrent PutMulti calls and call times
+type concurrencyWorker struct {
+	mutex    sync.Mutex
+	current  int
+	max      int
+	calls    []time.Time
+	failures int
+}
+
+func (cw *concurrencyWorker) PutMulti(events []interface{}) error {
+	cw.mutex.Lock()
+	cw.calls = append(cw.calls, time.Now())
+	fail := len(cw.calls) <= cw.failures
+	cw.current++
+	if cw.current > cw.max {
+		cw.max = cw.current
+	}
+	cw.mutex.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	cw.mutex.Lock()
+	cw.current--
+	cw.mutex.Unlock()
+	if fail {
+		return errTemporary
+	}
+	return nil
+}
+
+func TestRateLimitAndMaxInFlight(t *testing.T) {
+	cw := &concurrencyWorker{}
+	bp, err := NewBackpressureContext(cw, RateLimit(10, 0), MaxInFlight(1), Workers(4), BatchMaxSize(1), BatchTimeMs(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		bp.Add(i)
+	}
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+	// burst of 10 batches, 2 more at 10 batches/s
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected rate limited delivery, took %v", elapsed)
+	}
+	if cw.max != 1 {
+		t.Fatalf("expected at most 1 PutMulti in flight, got %v", cw.max)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	// 2 failures open the circuit, failed probe opens it again, second probe succeeds
+	cw := &concurrencyWorker{failures: 3}
+	bp, err := NewBackpressureContext(cw, CircuitBreaker(2, 50*time.Millisecond), Retry(10, time.Millisecond, time.Millisecond),
+		Workers(2), BatchMaxSize(1), BatchTimeMs(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.Add("event")
+	waitFor(t, func() bool { return bp.CircuitState() == CircuitOpen })
+	if dropped, err := bp.Shutdown(context.Background()); err != nil || dropped != 0 {
+		t.Fatalf("expected clean shutdown, got %v dropped, %v", dropped, err)
+	}
+
+	if len(cw.calls) != 4 || bp.CircuitState() != CircuitClosed {
+		t.Fatalf("expected 4 calls and closed circuit, got %v calls, %v", len(cw.calls), bp.CircuitState())
+	}
+	for _, i := range []int{2, 3} {
+		if pause := cw.calls[i].Sub(cw.calls[i-1]); pause < 50*time.Millisecond {
+			t.Fatalf("expected delivery paused before probe %v, got %v", i, pause)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package config
 import (
 	"errors"
 	"io/ioutil"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -36,21 +37,48 @@ type YamlConfig struct {
 	Mode        string           `yaml:"mode"`        // debug/release
 	AuthToken   AuthTokenSection `yaml:"auth_token"`
 	JWTToken    JWTTokenSection  `yaml:"jwt_token"`
+	Registry    RegistrySection  `yaml:"registry"`
 }
 
 // AuthTokenSection for simple authorization token
 type AuthTokenSection struct {
-	Enabled bool   `yaml:"enabled"`
-	Token   string `yaml:"token"`
-	Header  string `yaml:"header"`
-	Path    string `yaml:"path"`
+	Enabled bool              `yaml:"enabled"`
+	Token   string            `yaml:"token"`
+	Header  string            `yaml:"header"`
+	Path    string            `yaml:"path"`
+	HashKey string            `yaml:"hash_key"` // HMAC-SHA256 key of tokens hashes
+	Tokens  []APITokenSection `yaml:"tokens"`   // named tokens, replace token if set
+}
+
+// APITokenSection named API token stored as hash
+type APITokenSection struct {
+	Name    string    `yaml:"name"`
+	Hash    string    `yaml:"hash"`    // hex HMAC-SHA256 of the token with hash_key (auth.HashAPIToken)
+	Paths   []string  `yaml:"paths"`   // regular expressions matching the whole request path, all paths if empty
+	Methods []string  `yaml:"methods"` // HTTP methods, all if empty
+	Expires time.Time `yaml:"expires"` // optional expiry, e.g. 2021-12-31T00:00:00Z
 }
 
 // JWTTokenSection for JWT token authorization middleware
 type JWTTokenSection struct {
-	Enabled    bool   `yaml:"enabled"`
-	SecretKey  string `yaml:"secret_key"`
-	CookieName string `yaml:"cookie_name"`
+	Enabled     bool          `yaml:"enabled"`
+	SecretKey   string        `yaml:"secret_key"`
+	CookieName  string        `yaml:"cookie_name"`
+	JWKSURL     string        `yaml:"jwks_url"`     // public keys (RS256, ES256, EdDSA) selected by kid, instead of secret_key
+	JWKSFile    string        `yaml:"jwks_file"`    // local JWKS file, used if jwks_url is not set
+	JWKSRefresh time.Duration `yaml:"jwks_refresh"` // how often keys are reloaded, e.g. 1h
+	Algorithms  []string      `yaml:"algorithms"`   // accepted signing algorithms, default RS256, ES256 and EdDSA
+
+	Issuers        []string      `yaml:"issuers"`         // accepted iss values
+	Audiences      []string      `yaml:"audiences"`       // token aud must contain one of them
+	Leeway         time.Duration `yaml:"leeway"`          // allowed clock skew for exp, nbf and iat, e.g. 30s
+	MaxAge         time.Duration `yaml:"max_age"`         // maximum token age since iat
+	RequiredClaims []string      `yaml:"required_claims"` // claims every token must contain
+}
+
+// RegistrySection for docker registry access (dockerhub client and docker image pulls)
+type RegistrySection struct {
+	Mirrors []string `yaml:"mirrors"` // ordered list of mirrors tried before the upstream registry
 }
 
 // NewYamlConfig loads the conf.yaml file and return the new config
@@ -14,7 +14,10 @@ package config
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestLoadConfig(t *testing.T) {
 	var config YamlConfig
@@ -25,6 +28,18 @@ func TestLoadConfig(t *testing.T) {
 	if config.Port != 8080 {
 		t.Fatal("failed to read config")
 	}
+	if len(config.Registry.Mirrors) != 1 || config.Registry.Mirrors[0] != "https://mirror.local:5000" {
+		t.Fatal("failed to read registry mirrors")
+	}
+	if config.JWTToken.JWKSURL != "https://auth.local/.well-known/jwks.json" || config.JWTToken.JWKSRefresh != time.Hour || len(config.JWTToken.Algorithms) != 2 {
+		t.Fatal("failed to read jwks settings")
+	}
+	if len(config.JWTToken.Issuers) != 1 || config.JWTToken.Leeway != 30*time.Second {
+		t.Fatal("failed to read jwt validation settings")
+	}
+	if len(config.AuthToken.Tokens) != 1 || config.AuthToken.Tokens[0].Name != "ci" || config.AuthToken.Tokens[0].Expires.Year() != 2030 {
+		t.Fatal("failed to read api tokens")
+	}
 }
 
 type embeddedConfig struct {
@@ -37,6 +37,7 @@ import (
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/stdcopy"
 )
 
@@ -48,6 +49,9 @@ type Options struct {
 	CACert     []byte
 	KeyCert    []byte
 	Cert       []byte
+	Verifier   ImageVerifier
+	Mirrors    []string
+	Recorder   func(endpoint, reference string)
 }
 
 // Option a single option
@@ -95,6 +99,27 @@ func Cert(cert []byte) Option {
 	}
 }
 
+// ImageVerification - verifies images before ContainerReplace deploys them
+func ImageVerification(verifier ImageVerifier) Option {
+	return func(args *Options) {
+		args.Verifier = verifier
+	}
+}
+
+// Mirrors - ordered list of registry mirrors (e.g. mirror.local:5000) tried before docker hub when pulling images
+func Mirrors(mirrors ...string) Option {
+	return func(args *Options) {
+		args.Mirrors = mirrors
+	}
+}
+
+// EndpointRecorder - called with the endpoint (mirror or docker.io) that served each image pull
+func EndpointRecorder(recorder func(endpoint, reference string)) Option {
+	return func(args *Options) {
+		args.Recorder = recorder
+	}
+}
+
 // Client - digitalocean abstraction
 type Client struct {
 	client     *client.Client
@@ -102,8 +127,13 @@ type Client struct {
 	host       string
 	version    string
 	log        mclog.Logger
+	verifier   ImageVerifier
+	mirrors    []string
+	recorder   func(endpoint, reference string)
 }
 
+var _ DockerVerifier = (*Client)(nil)
+
 func NewSocketClient(opts ...Option) Docker {
 	args := &Options{}
 	for _, op := range opts {
@@ -123,10 +153,13 @@ func NewSocketClient(opts ...Option) Docker {
 		panic("failed to init docker client")
 	}
 	return &Client{
-		client:  cl,
-		host:    args.Host,
-		version: args.APIVersion,
-		log:     args.Log,
+		client:   cl,
+		host:     args.Host,
+		version:  args.APIVersion,
+		log:      args.Log,
+		verifier: args.Verifier,
+		mirrors:  mirrorHosts(args.Mirrors),
+		recorder: args.Recorder,
 	}
 }
 
@@ -166,6 +199,9 @@ func NewLocalClient(opts ...Option) Docker {
 		host:       args.Host,
 		version:    args.APIVersion,
 		log:        args.Log,
+		verifier:   args.Verifier,
+		mirrors:    mirrorHosts(args.Mirrors),
+		recorder:   args.Recorder,
 	}
 }
 
@@ -226,6 +262,9 @@ func NewTLSClient(opts ...Option) Docker {
 		host:       args.Host,
 		version:    args.APIVersion,
 		log:        args.Log,
+		verifier:   args.Verifier,
+		mirrors:    mirrorHosts(args.Mirrors),
+		recorder:   args.Recorder,
 	}
 }
 
@@ -433,7 +472,8 @@ func (cl *Client) VolumesPrune(pruneFilter filters.Args) (*types.VolumesPruneRep
 	return &prune, nil
 }
 
-// ImagePullDockerHub - pull private image from docker hub (it waits for pull to finish)
+// ImagePullDockerHub - pull private image from docker hub (it waits for pull to finish). Configured mirrors are tried first
+// (without docker hub credentials) and the image pulled from a mirror is tagged as docker.io/image:tag
 func (cl *Client) ImagePullDockerHub(image, tag string, username, password string) (string, error) {
 	authConfig := types.AuthConfig{
 		Username: username,
@@ -443,31 +483,124 @@ func (cl *Client) ImagePullDockerHub(image, tag string, username, password strin
 	if err != nil {
 		if cl.log != nil {
 			cl.log.Error("failed to unmarshall auth config", err)
-			return "", err
 		}
+		return "", err
 	}
 	authStr := base64.URLEncoding.EncodeToString(encodedJSON)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
 
-	out, err := cl.client.ImagePull(ctx, "docker.io/"+image+":"+tag, types.ImagePullOptions{RegistryAuth: authStr})
+	upstreamRef := "docker.io/" + image + ":" + tag
+	for _, mirror := range cl.mirrors {
+		// official images are under library/ in the registry API the mirrors expose
+		mirrorRef := mirror + "/" + officialRepository(image) + ":" + tag
+		// docker hub credentials are never sent to mirrors
+		logStr, pErr := cl.imagePull(ctx, mirrorRef, "")
+		if pErr == nil {
+			pErr = cl.client.ImageTag(ctx, mirrorRef, upstreamRef)
+		}
+		if pErr == nil {
+			cl.served(mirror, upstreamRef)
+			return logStr, nil
+		}
+		if cl.log != nil {
+			cl.log.Warn("failed to pull from registry mirror, trying next endpoint", mirrorRef, pErr)
+		}
+	}
+
+	logStr, err := cl.imagePull(ctx, upstreamRef, authStr)
 	if err != nil {
 		if cl.log != nil {
-			cl.log.Error("failed to pull docker image", "docker.io/ "+image+":"+tag)
-			return "", err
+			cl.log.Error("failed to pull docker image", upstreamRef, err)
 		}
+		return "", err
+	}
+	cl.served("docker.io", upstreamRef)
+	return logStr, nil
+}
+
+// imagePull pulls the image and waits for pull to finish. Errors reported within the progress stream are returned as error
+func (cl *Client) imagePull(ctx context.Context, ref string, authStr string) (string, error) {
+	out, err := cl.client.ImagePull(ctx, ref, types.ImagePullOptions{RegistryAuth: authStr})
+	if err != nil {
+		return "", err
 	}
+	defer out.Close()
+
 	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, out)
-	if err != nil && err != io.EOF {
+	decoder := json.NewDecoder(io.TeeReader(out, buf))
+	for {
+		var msg jsonmessage.JSONMessage
+		if dErr := decoder.Decode(&msg); dErr != nil {
+			if dErr == io.EOF {
+				break
+			}
+			return buf.String(), dErr
+		}
+		if msg.Error != nil {
+			return buf.String(), msg.Error
+		}
+	}
+	return buf.String(), nil
+}
+
+func (cl *Client) served(endpoint, reference string) {
+	if cl.recorder != nil {
+		cl.recorder(endpoint, reference)
+	}
+}
+
+// ImageVerify - verifies local image with configured ImageVerifier. Returns verified digest
+func (cl *Client) ImageVerify(image, tag string) (string, error) {
+	digest, _, err := cl.verifyImage(image, tag)
+	return digest, err
+}
+
+// verifyImage returns verified digest of image:tag and the local repository@digest reference of the verified content
+func (cl *Client) verifyImage(image, tag string) (string, string, error) {
+	if cl.verifier == nil {
+		return "", "", ErrNoImageVerifier
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	inspect, _, err := cl.client.ImageInspectWithRaw(ctx, image+":"+tag)
+	if err != nil {
 		if cl.log != nil {
-			cl.log.Error("failed to copy logs to string", err)
-			return "", err
+			cl.log.Error("failed to inspect image", image+":"+tag, err)
 		}
+		return "", "", err
 	}
-	logStr := buf.String()
-	return logStr, nil
+	digest, err := cl.verifier.VerifyImage(image, tag, cl.upstreamDigests(inspect.RepoDigests))
+	if err != nil {
+		return "", "", err
+	}
+	// images pulled from a mirror are only known locally by the mirror repository digest
+	reference := image + "@" + digest
+	for _, rd := range inspect.RepoDigests {
+		if strings.HasSuffix(rd, "@"+digest) {
+			reference = rd
+			break
+		}
+	}
+	return digest, reference, nil
+}
+
+// upstreamDigests rewrites RepoDigests of images pulled from a mirror (mirror/library/nginx@sha256:...)
+// to docker.io, since mirrors serve docker hub content with the same manifest digests
+func (cl *Client) upstreamDigests(repoDigests []string) []string {
+	digests := make([]string, 0, len(repoDigests))
+	for _, rd := range repoDigests {
+		for _, mirror := range cl.mirrors {
+			if strings.HasPrefix(rd, mirror+"/") {
+				rd = "docker.io/" + strings.TrimPrefix(rd, mirror+"/")
+				break
+			}
+		}
+		digests = append(digests, rd)
+	}
+	return digests
 }
 
 // ImageRemove - removes an image by force and prunes its children
@@ -529,8 +662,25 @@ func (cl *Client) CalculateStats(jsonStats *types.StatsJSON) *models.Stats {
 	return stats
 }
 
+// ContainerReplace - replaces container with a new one from image:tag. If ImageVerification is configured the image must pass verification first
+// and the container is created from the verified digest, so retagging image:tag in the meantime has no effect
 func (cl *Client) ContainerReplace(containerID string, image string, tag string) error {
 
+	reference := image + ":" + tag
+	if cl.verifier != nil {
+		digest, verified, vErr := cl.verifyImage(image, tag)
+		if vErr != nil {
+			if cl.log != nil {
+				cl.log.Error("image verification failed, refusing to replace container", containerID, image+":"+tag, vErr)
+			}
+			return vErr
+		}
+		if cl.log != nil {
+			cl.log.Info("image verified", image+":"+tag, digest)
+		}
+		reference = verified
+	}
+
 	originalContainer, err := cl.ContainerGet(containerID)
 	if err != nil {
 		if cl.log != nil {
@@ -564,7 +714,7 @@ func (cl *Client) ContainerReplace(containerID string, image string, tag string)
 
 	originalConf := originalContainer.Config
 	// replace image with the new image
-	originalConf.Image = image + ":" + tag
+	originalConf.Image = reference
 
 	newlyCreatedContainer, ccErr := cl.ContainerCreate(originalContainerName, originalConf, originalContainer.HostConfig, nil)
 	if ccErr != nil {
@@ -661,6 +811,29 @@ func (cl *Client) SystemWideInfo() (types.Info, types.DiskUsage, error) {
 	return info, diskUsage, nil
 }
 
+// mirrorHosts strips scheme and trailing slash since image references contain only the registry host
+func mirrorHosts(mirrors []string) []string {
+	hosts := make([]string, 0, len(mirrors))
+	for _, m := range mirrors {
+		m = strings.TrimSpace(m)
+		m = strings.TrimPrefix(m, "https://")
+		m = strings.TrimPrefix(m, "http://")
+		m = strings.TrimSuffix(m, "/")
+		if m != "" {
+			hosts = append(hosts, m)
+		}
+	}
+	return hosts
+}
+
+// officialRepository adds library namespace to official images (nginx -> library/nginx)
+func officialRepository(image string) string {
+	if strings.Contains(image, "/") {
+		return image
+	}
+	return "library/" + image
+}
+
 func calculateBlockIO(blkio types.BlkioStats) (blkRead uint64, blkWrite uint64) {
 	for _, bioEntry := range blkio.IoServiceBytesRecursive {
 		switch strings.ToLower(bioEntry.Op) {
@@ -55,3 +55,9 @@ type Docker interface {
 	// host system information
 	SystemWideInfo() (types.Info, types.DiskUsage, error)
 }
+
+// DockerVerifier verification of local images, implemented by the client returned from NewSocketClient, NewLocalClient and NewTLSClient
+type DockerVerifier interface {
+	// ImageVerify verifies local image:tag with the configured ImageVerifier and returns verified digest
+	ImageVerify(image, tag string) (string, error)
+}
@@ -0,0 +1,355 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/chryscloud/go-microkit-plugins/dockerhub"
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+)
+
+var (
+	// ErrImageNotApproved when there is no approval (nor signature source) for the image
+	ErrImageNotApproved = errors.New("image is not approved for deployment")
+	// ErrDigestMismatch when pulled image doesn't have the approved digest
+	ErrDigestMismatch = errors.New("image digest doesn't match the approved digest")
+	// ErrSignatureInvalid when none of the signatures is valid for the image digest and configured keys
+	ErrSignatureInvalid = errors.New("no valid signature found for image digest")
+	// ErrNoImageVerifier when verification is requested but no verifier configured
+	ErrNoImageVerifier = errors.New("image verifier not configured")
+	// ErrInvalidPublicKey when PEM public key cannot be parsed or is of unsupported type
+	ErrInvalidPublicKey = errors.New("invalid or unsupported public key")
+)
+
+const (
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// ImageVerifier verifies a pulled image before deployment
+type ImageVerifier interface {
+	// VerifyImage checks RepoDigests of a local image and returns the verified digest
+	VerifyImage(image, tag string, repoDigests []string) (string, error)
+}
+
+// Approval of image:tag for deployment
+type Approval struct {
+	Digest    string // expected manifest digest, e.g. sha256:...
+	Signature []byte // optional detached signature over the Digest string (e.g. ed25519)
+}
+
+// Signature detached signature of a manifest digest
+type Signature struct {
+	Digest    string // manifest digest the payload refers to
+	Payload   []byte // signed payload
+	Signature []byte
+}
+
+// SignatureSource returns detached signatures of a manifest digest (e.g. cosign signatures stored in the registry)
+type SignatureSource interface {
+	Signatures(repository, digest string) ([]Signature, error)
+}
+
+// Registry raw access to manifests and blobs (e.g. dockerhub.Client)
+type Registry interface {
+	Manifest(repository, reference string) ([]byte, string, error)
+	Blob(repository, digest string) ([]byte, error)
+}
+
+// VerifierOptions settings of the image verifier
+type VerifierOptions struct {
+	Log        mclog.Logger
+	Keys       []crypto.PublicKey
+	Signatures SignatureSource
+	Approvals  map[string]Approval
+}
+
+// VerifierOption a single verifier option
+type VerifierOption func(*VerifierOptions)
+
+// VerifierLog - recommended to be enabled at all times
+func VerifierLog(log mclog.Logger) VerifierOption {
+	return func(args *VerifierOptions) {
+		args.Log = log
+	}
+}
+
+// VerifierKeys - trusted public keys (ed25519, ecdsa P-256 or rsa) for signature verification
+func VerifierKeys(keys ...crypto.PublicKey) VerifierOption {
+	return func(args *VerifierOptions) {
+		args.Keys = append(args.Keys, keys...)
+	}
+}
+
+// VerifierSignatures - requires a valid signature from the source for every image (e.g. NewCosignSignatures)
+func VerifierSignatures(source SignatureSource) VerifierOption {
+	return func(args *VerifierOptions) {
+		args.Signatures = source
+	}
+}
+
+// VerifierApproval - approves image:tag with expected digest (and optional signature)
+func VerifierApproval(image, tag string, approval Approval) VerifierOption {
+	return func(args *VerifierOptions) {
+		if args.Approvals == nil {
+			args.Approvals = make(map[string]Approval)
+		}
+		args.Approvals[normalizeRepository(image)+":"+tag] = approval
+	}
+}
+
+// Verifier compares RepoDigests against approved digests and checks detached signatures
+type Verifier struct {
+	log        mclog.Logger
+	keys       []crypto.PublicKey
+	signatures SignatureSource
+	mutex      sync.RWMutex
+	approvals  map[string]Approval
+}
+
+// NewVerifier creates image verifier. Image is accepted if it's approved (digest matches, signature valid if present)
+// and in case signature source is configured, a valid signature exists for its digest
+func NewVerifier(opts ...VerifierOption) *Verifier {
+	args := &VerifierOptions{
+		Approvals: make(map[string]Approval),
+	}
+	for _, op := range opts {
+		if op != nil {
+			op(args)
+		}
+	}
+	return &Verifier{
+		log:        args.Log,
+		keys:       args.Keys,
+		signatures: args.Signatures,
+		approvals:  args.Approvals,
+	}
+}
+
+// Approve image:tag at runtime (e.g. from release pipeline)
+func (v *Verifier) Approve(image, tag string, approval Approval) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.approvals[normalizeRepository(image)+":"+tag] = approval
+}
+
+// VerifyImage checks RepoDigests of the local image against approval and signatures. Returns verified digest
+func (v *Verifier) VerifyImage(image, tag string, repoDigests []string) (string, error) {
+	repository := normalizeRepository(image)
+	digests := imageDigests(repository, repoDigests)
+
+	v.mutex.RLock()
+	approval, approved := v.approvals[repository+":"+tag]
+	v.mutex.RUnlock()
+
+	var digest string
+	if approved {
+		if !digests[approval.Digest] {
+			v.logError("image digest mismatch", repository+":"+tag, approval.Digest, repoDigests)
+			return "", ErrDigestMismatch
+		}
+		digest = approval.Digest
+		if approval.Signature != nil {
+			sig := Signature{Digest: digest, Payload: []byte(digest), Signature: approval.Signature}
+			if !v.verifySignature(sig) {
+				v.logError("invalid approval signature", repository+":"+tag, digest)
+				return "", ErrSignatureInvalid
+			}
+		}
+	} else {
+		if v.signatures == nil || len(digests) != 1 {
+			v.logError("image not approved", repository+":"+tag, repoDigests)
+			return "", ErrImageNotApproved
+		}
+		for d := range digests {
+			digest = d
+		}
+	}
+
+	if v.signatures != nil {
+		sigs, err := v.signatures.Signatures(repository, digest)
+		if err != nil {
+			v.logError("failed to retrieve image signatures", repository, digest, err)
+			return "", err
+		}
+		valid := false
+		for _, sig := range sigs {
+			if sig.Digest == digest && v.verifySignature(sig) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			v.logError("no valid image signature", repository, digest)
+			return "", ErrSignatureInvalid
+		}
+	}
+	return digest, nil
+}
+
+func (v *Verifier) verifySignature(sig Signature) bool {
+	for _, key := range v.keys {
+		if VerifySignature(key, sig.Payload, sig.Signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) logError(keyvals ...interface{}) {
+	if v.log != nil {
+		v.log.Error(keyvals...)
+	}
+}
+
+// VerifySignature verifies signature of the payload. Supported keys: ed25519 (raw payload), ecdsa (ASN.1 over sha256) and rsa (PKCS1v15 over sha256)
+func VerifySignature(key crypto.PublicKey, payload, signature []byte) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return len(k) == ed25519.PublicKeySize && ed25519.Verify(k, payload, signature)
+	case *ecdsa.PublicKey:
+		var esig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(signature, &esig); err != nil || len(rest) > 0 {
+			return false
+		}
+		hash := sha256.Sum256(payload)
+		return ecdsa.Verify(k, hash[:], esig.R, esig.S)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(payload)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil
+	}
+	return false
+}
+
+// ParsePublicKey parses PEM encoded PKIX public key (e.g. cosign.pub)
+func ParsePublicKey(pemKey []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, ErrInvalidPublicKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+		return key, nil
+	}
+	return nil, ErrInvalidPublicKey
+}
+
+// CosignSignatures reads cosign signatures stored as registry artifacts (tag sha256-<hex>.sig)
+type CosignSignatures struct {
+	registry Registry
+}
+
+type cosignManifest struct {
+	Layers []struct {
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// NewCosignSignatures signature source backed by registry
+func NewCosignSignatures(registry Registry) *CosignSignatures {
+	return &CosignSignatures{registry: registry}
+}
+
+// Signatures of the manifest digest. Returns no signatures (and no error) if image isn't signed
+func (cs *CosignSignatures) Signatures(repository, digest string) ([]Signature, error) {
+	sigTag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	raw, _, err := cs.registry.Manifest(repository, sigTag)
+	if err != nil {
+		if errors.Is(err, dockerhub.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var manifest cosignManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, err
+	}
+	signatures := make([]Signature, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		encoded, ok := layer.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		payload, err := cs.registry.Blob(repository, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		var p cosignPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			continue
+		}
+		signatures = append(signatures, Signature{
+			Digest:    p.Critical.Image.DockerManifestDigest,
+			Payload:   payload,
+			Signature: sig,
+		})
+	}
+	return signatures, nil
+}
+
+// normalizeRepository strips default registry and library namespace (docker.io/library/nginx -> nginx)
+func normalizeRepository(image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "index.docker.io/")
+	image = strings.TrimPrefix(image, "library/")
+	return image
+}
+
+// imageDigests digests from RepoDigests (repository@sha256:...) of the given repository
+func imageDigests(repository string, repoDigests []string) map[string]bool {
+	digests := make(map[string]bool)
+	for _, rd := range repoDigests {
+		i := strings.LastIndex(rd, "@")
+		if i < 0 {
+			continue
+		}
+		if normalizeRepository(rd[:i]) == repository {
+			digests[rd[i+1:]] = true
+		}
+	}
+	return digests
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 Wearless Tech Inc All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chryscloud/go-microkit-plugins/dockerhub"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	testDigest = "sha256:8f2ff0d6b9ec3f0c0f1e1bd1e1b0a4ec0e0e1c7f4e2f7c5b1a9d3b9b0c1d2e3f"
+)
+
+var testRepoDigests = []string{"chryscloud/chrysedgeproxy@" + testDigest}
+
+func TestVerifyApprovedDigest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := ed25519.Sign(priv, []byte(testDigest))
+
+	v := NewVerifier(VerifierLog(zl), VerifierKeys(pub),
+		VerifierApproval("docker.io/chryscloud/chrysedgeproxy", "0.0.4", Approval{Digest: testDigest, Signature: signature}))
+
+	digest, err := v.VerifyImage("chryscloud/chrysedgeproxy", "0.0.4", testRepoDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Fatalf("expected %v, got %v", testDigest, digest)
+	}
+
+	if _, err := v.VerifyImage("chryscloud/chrysedgeproxy", "0.0.4", []string{"chryscloud/chrysedgeproxy@sha256:other"}); err != ErrDigestMismatch {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+	if _, err := v.VerifyImage("chryscloud/chrysedgeproxy", "0.0.5", testRepoDigests); err != ErrImageNotApproved {
+		t.Fatalf("expected not approved, got %v", err)
+	}
+
+	v.Approve("chryscloud/chrysedgeproxy", "0.0.5", Approval{Digest: testDigest, Signature: []byte("invalid")})
+	if _, err := v.VerifyImage("chryscloud/chrysedgeproxy", "0.0.5", testRepoDigests); err != ErrSignatureInvalid {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+}
+
+type fakeRegistry struct {
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+func (r *fakeRegistry) Manifest(repository, reference string) ([]byte, string, error) {
+	m, ok := r.manifests[repository+":"+reference]
+	if !ok {
+		return nil, "", dockerhub.ErrNotFound
+	}
+	return m, "application/vnd.oci.image.manifest.v1+json", nil
+}
+
+func (r *fakeRegistry) Blob(repository, digest string) ([]byte, error) {
+	b, ok := r.blobs[digest]
+	if !ok {
+		return nil, dockerhub.ErrNotFound
+	}
+	return b, nil
+}
+
+func TestVerifyCosignSignature(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"chryscloud/chrysedgeproxy"},"image":{"docker-manifest-digest":"` + testDigest + `"},"type":"cosign container image signature"},"optional":null}`)
+	hash := sha256.Sum256(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, priv, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"layers": []map[string]interface{}{{
+			"digest":      "sha256:payload",
+			"annotations": map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+		}},
+	})
+	registry := &fakeRegistry{
+		manifests: map[string][]byte{"chryscloud/chrysedgeproxy:sha256-8f2ff0d6b9ec3f0c0f1e1bd1e1b0a4ec0e0e1c7f4e2f7c5b1a9d3b9b0c1d2e3f.sig": manifest},
+		blobs:     map[string][]byte{"sha256:payload": payload},
+	}
+
+	v := NewVerifier(VerifierKeys(pub), VerifierSignatures(NewCosignSignatures(registry)))
+	if _, err := v.VerifyImage("chryscloud/chrysedgeproxy", "latest", testRepoDigests); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	v = NewVerifier(VerifierKeys(&other.PublicKey), VerifierSignatures(NewCosignSignatures(registry)))
+	if _, err := v.VerifyImage("chryscloud/chrysedgeproxy", "latest", testRepoDigests); err != ErrSignatureInvalid {
+		t.Fatalf("expected invalid signature with untrusted key, got %v", err)
+	}
+
+	// unsigned image
+	if _, err := v.VerifyImage("chryscloud/chrysedgeproxy", "latest", []string{"chryscloud/chrysedgeproxy@sha256:unsigned"}); err != ErrSignatureInvalid {
+		t.Fatalf("expected invalid signature for unsigned image, got %v", err)
+	}
+}
+
+func TestVerifyAfterMirrorPull(t *testing.T) {
+	var pulled, tagged []string
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/create"):
+			if r.Header.Get("X-Registry-Auth") != "" {
+				t.Errorf("docker hub credentials sent with %v", r.URL.Query().Get("fromImage"))
+			}
+			pulled = append(pulled, r.URL.Query().Get("fromImage"))
+			w.Write([]byte(`{"status":"Downloaded newer image"}`))
+		case strings.HasSuffix(r.URL.Path, "/tag"):
+			tagged = append(tagged, r.URL.Query().Get("repo"))
+			w.WriteHeader(http.StatusCreated)
+		case strings.HasSuffix(r.URL.Path, "/images/nginx:1.19/json"):
+			json.NewEncoder(w).Encode(types.ImageInspect{RepoDigests: []string{"mirror.local:5000/library/nginx@" + testDigest}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer daemon.Close()
+
+	v := NewVerifier(VerifierApproval("nginx", "1.19", Approval{Digest: testDigest}))
+	cl := NewSocketClient(Host("tcp://"+daemon.Listener.Addr().String()), Mirrors("https://mirror.local:5000/"), ImageVerification(v))
+	if _, err := cl.ImagePullDockerHub("nginx", "1.19", "user", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 1 || pulled[0] != "mirror.local:5000/library/nginx" {
+		t.Fatalf("expected pull of official image from mirror, got %v", pulled)
+	}
+	if len(tagged) != 1 || tagged[0] != "nginx" {
+		t.Fatalf("expected mirrored image tagged as docker.io, got %v", tagged)
+	}
+
+	digest, err := cl.(DockerVerifier).ImageVerify("nginx", "1.19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Fatalf("expected %v, got %v", testDigest, digest)
+	}
+}
+
+func TestContainerReplaceVerifiedDigest(t *testing.T) {
+	var created string
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/chryscloud/chrysedgeproxy:0.0.4/json"):
+			json.NewEncoder(w).Encode(types.ImageInspect{RepoDigests: testRepoDigests})
+		case strings.HasSuffix(r.URL.Path, "/containers/old/json"):
+			json.NewEncoder(w).Encode(types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{ID: "old", Name: "/proxy", HostConfig: &container.HostConfig{}},
+				Config:            &container.Config{Image: "chryscloud/chrysedgeproxy:0.0.3"},
+			})
+		case strings.HasSuffix(r.URL.Path, "/containers/create"):
+			var conf container.Config
+			json.NewDecoder(r.Body).Decode(&conf)
+			created = conf.Image
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"new"}`))
+		case strings.HasSuffix(r.URL.Path, "/stop"), strings.HasSuffix(r.URL.Path, "/rename"),
+			strings.HasSuffix(r.URL.Path, "/start"), r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer daemon.Close()
+
+	v := NewVerifier(VerifierApproval("chryscloud/chrysedgeproxy", "0.0.4", Approval{Digest: testDigest}), nil)
+	cl := NewSocketClient(Host("tcp://"+daemon.Listener.Addr().String()), ImageVerification(v))
+	if err := cl.ContainerReplace("old", "chryscloud/chrysedgeproxy", "0.0.4"); err != nil {
+		t.Fatal(err)
+	}
+	if created != "chryscloud/chrysedgeproxy@"+testDigest {
+		t.Fatalf("expected container created from verified digest, got %v", created)
+	}
+}
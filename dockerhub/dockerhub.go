@@ -1,10 +1,10 @@
 package dockerhub
 
 import (
-	"errors"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 	"unicode/utf8"
 
 	mclog "github.com/chryscloud/go-microkit-plugins/log"
@@ -15,14 +15,37 @@ var (
 	authURL     = "https://auth.docker.io/token"
 	serviceURL  = "registry.docker.io"
 	registryURL = "https://registry-1.docker.io"
+
+	// docker hub dedicated repository for checking rate limits
+	rateLimitRepository = "ratelimitpreview/test"
+)
+
+var manifestHeaders = map[string]string{
+	"Accept": strings.Join([]string{
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.oci.image.manifest.v1+json",
+	}, ","),
+}
+
+const (
+	defaultRetryMinWait = 500 * time.Millisecond
+	defaultRetryMaxWait = 30 * time.Second
 )
 
 // Options for digital ocean
 type Options struct {
-	Log      mclog.Logger
-	Host     string
-	username string
-	password string
+	Log          mclog.Logger
+	Host         string
+	AuthURL      string
+	MaxRetries   int
+	RetryMinWait time.Duration
+	RetryMaxWait time.Duration
+	Mirrors      []string
+	Recorder     func(endpoint, path string)
+	username     string
+	password     string
 }
 
 // Option a single option
@@ -42,6 +65,38 @@ func Host(remoteHost string) Option {
 	}
 }
 
+// AuthURL - token service, default = https://auth.docker.io/token
+func AuthURL(url string) Option {
+	return func(args *Options) {
+		args.AuthURL = url
+	}
+}
+
+// Retry - retries network errors, rate limited (429) and temporary unavailable (502, 503, 504) requests with
+// exponential backoff and jitter between minWait and maxWait. Retry-After header is honored unless it exceeds maxWait.
+// Default: no retries
+func Retry(maxRetries int, minWait, maxWait time.Duration) Option {
+	return func(args *Options) {
+		args.MaxRetries = maxRetries
+		args.RetryMinWait = minWait
+		args.RetryMaxWait = maxWait
+	}
+}
+
+// Mirrors - ordered list of registry mirrors (e.g. https://mirror.local:5000) tried before the upstream host
+func Mirrors(mirrors ...string) Option {
+	return func(args *Options) {
+		args.Mirrors = mirrors
+	}
+}
+
+// EndpointRecorder - called with the endpoint (mirror or upstream host) that served each registry request
+func EndpointRecorder(recorder func(endpoint, path string)) Option {
+	return func(args *Options) {
+		args.Recorder = recorder
+	}
+}
+
 // Credentials - optionsl
 func Credentials(username, password string) Option {
 	return func(args *Options) {
@@ -53,14 +108,26 @@ func Credentials(username, password string) Option {
 // Client - dockerhub abstraction
 type Client struct {
 	host       string
+	authURL    string
 	log        mclog.Logger
 	httpClient *resty.Client
+	retry      retryPolicy
+	mirrors    []string
+	recorder   func(endpoint, path string)
 	username   string
 	password   string
 	token      string
 	mutex      *sync.Mutex
 }
 
+// RateLimitStatus - docker hub pull quota
+type RateLimitStatus struct {
+	Limit     int           // number of pulls allowed within the window (-1 if unlimited)
+	Remaining int           // remaining pulls within the window (-1 if unlimited)
+	Window    time.Duration // sliding window of the limit (e.g. 6 hours)
+	Source    string        // the quota is counted against (IP address or user ID)
+}
+
 type authResponse struct {
 	Token     string `json:"token"`
 	ExpiresIn int    `json:"expires_in"`
@@ -72,7 +139,9 @@ type tagsResponse struct {
 	Tags []string `json:"tags"`
 }
 
-// NewClient new DockerHub client to interactions with private or public docker hub (currently only Tags method supported)
+var _ RegistryClient = (*Client)(nil)
+
+// NewClient new DockerHub client to interactions with private or public docker hub. Assert RegistryClient for digests, manifests, blobs and rate limit
 func NewClient(opts ...Option) DockerHub {
 	args := &Options{}
 	for _, op := range opts {
@@ -83,13 +152,20 @@ func NewClient(opts ...Option) DockerHub {
 	if args.Host == "" {
 		args.Host = registryURL
 	}
+	if args.AuthURL == "" {
+		args.AuthURL = authURL
+	}
 	cl := resty.New().SetHeader("Content-Type", "application/json").SetHeader("Docker-Distribution-Api-Version", "registry/2.0")
 	cl.Debug = false
 
 	outClient := &Client{
 		host:       args.Host,
+		authURL:    args.AuthURL,
 		log:        args.Log,
 		httpClient: cl,
+		retry:      newRetryPolicy(args),
+		mirrors:    trimMirrors(args.Mirrors),
+		recorder:   args.Recorder,
 		mutex:      &sync.Mutex{},
 	}
 	if args.username != "" {
@@ -103,80 +179,192 @@ func NewClient(opts ...Option) DockerHub {
 
 // Tags - returns the list of tags from the dockerhub repository
 func (client *Client) Tags(repository string) ([]string, error) {
-	// remove first slash if exists in repository
-	if strings.HasPrefix(repository, "/") {
-		_, i := utf8.DecodeRuneInString(repository)
-		repository = repository[i:]
-	}
-	url := client.host + "/v2/" + repository + "/tags/list"
+	repository = slashFirstSlash(repository)
 
 	var tagsResponse tagsResponse
-	var tagsErr error
-	var tagsGetResp *resty.Response
+	_, err := client.registryGet(repository, "/v2/"+repository+"/tags/list", nil, &tagsResponse)
+	if err != nil {
+		return nil, err
+	}
+	return tagsResponse.Tags, nil
+}
 
-	var token authResponse
-	if client.token == "" {
-		t, err := client.retrieveAuthToken(repository)
-		if err != nil {
-			return nil, err
+// Digest - returns the manifest digest the tag points to (manifest list digest for multi-arch images)
+func (client *Client) Digest(repository, tag string) (string, error) {
+	repository = slashFirstSlash(repository)
+	resp, err := client.registryRequest(http.MethodHead, repository, "/v2/"+repository+"/manifests/"+tag, manifestHeaders, nil)
+	if err != nil {
+		return "", err
+	}
+	digest := resp.Header().Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", ErrNotFound
+	}
+	return digest, nil
+}
+
+// Manifest - returns raw manifest and its media type. Reference is either a tag or a digest
+func (client *Client) Manifest(repository, reference string) ([]byte, string, error) {
+	repository = slashFirstSlash(repository)
+	resp, err := client.registryGet(repository, "/v2/"+repository+"/manifests/"+reference, manifestHeaders, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body(), resp.Header().Get("Content-Type"), nil
+}
+
+// Blob - returns content of the blob (e.g. image config or signature payload)
+func (client *Client) Blob(repository, digest string) ([]byte, error) {
+	repository = slashFirstSlash(repository)
+	resp, err := client.registryGet(repository, "/v2/"+repository+"/blobs/"+digest, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body(), nil
+}
+
+// RateLimit - returns the current pull quota for the client credentials (or anonymous pulls from this IP address).
+// Querying the quota doesn't count as a pull. Always asks docker hub, mirrors don't report its quota
+func (client *Client) RateLimit() (*RateLimitStatus, error) {
+	resp, err := client.upstreamRequest(http.MethodHead, rateLimitRepository, "/v2/"+rateLimitRepository+"/manifests/latest", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	status := &RateLimitStatus{Source: resp.Header().Get("docker-ratelimit-source")}
+	status.Limit, status.Window = parseRateLimitHeader(resp.Header().Get("ratelimit-limit"))
+	status.Remaining, _ = parseRateLimitHeader(resp.Header().Get("ratelimit-remaining"))
+	return status, nil
+}
+
+// registryGet - authorized GET request to the registry
+func (client *Client) registryGet(repository, path string, headers map[string]string, result interface{}) (*resty.Response, error) {
+	return client.registryRequest(http.MethodGet, repository, path, headers, result)
+}
+
+// registryRequest - tries each mirror once (without upstream token) and falls back to upstream registry on failure
+func (client *Client) registryRequest(method, repository, path string, headers map[string]string, result interface{}) (*resty.Response, error) {
+	for _, mirror := range client.mirrors {
+		resp, err := client.anonymousRequest(headers, result).Execute(method, mirror+path)
+		if err == nil {
+			err = responseError(resp)
+		}
+		if err == nil {
+			client.served(mirror, path)
+			return resp, nil
+		}
+		if client.log != nil {
+			client.log.Warn("registry mirror failed, trying next endpoint", mirror, path, err)
 		}
-		token = *t
-		client.mutex.Lock()
-		client.token = token.Token
-		client.mutex.Unlock()
 	}
+	resp, err := client.upstreamRequest(method, repository, path, headers, result)
+	if err != nil {
+		return nil, err
+	}
+	client.served(client.host, path)
+	return resp, nil
+}
 
-	tagsGetResp, tagsErr = client.httpClient.R().SetHeader("Authorization", "Bearer "+client.token).SetResult(&tagsResponse).Get(url)
-	if tagsGetResp.StatusCode() == http.StatusUnauthorized {
-		t, err := client.retrieveAuthToken(repository)
-		if err != nil {
+// upstreamRequest - authorized request to the registry. Requests a new token in case current one is missing, expired or has a different scope
+func (client *Client) upstreamRequest(method, repository, path string, headers map[string]string, result interface{}) (*resty.Response, error) {
+	if client.currentToken() == "" {
+		if err := client.refreshAuthToken(repository); err != nil {
 			return nil, err
 		}
-		token = *t
-		client.mutex.Lock()
-		client.token = token.Token
-		client.mutex.Unlock()
-
-		tagsGetResp, tagsErr = client.httpClient.R().SetResult(&tagsResponse).SetHeader("Authorization", "Bearer "+client.token).Get(url)
+	}
 
+	resp, err := client.retry.do(client.log, func() (*resty.Response, error) {
+		return client.request(headers, result).Execute(method, client.host+path)
+	})
+	if err == nil && resp.StatusCode() == http.StatusUnauthorized {
+		if aErr := client.refreshAuthToken(repository); aErr != nil {
+			return nil, aErr
+		}
+		resp, err = client.retry.do(client.log, func() (*resty.Response, error) {
+			return client.request(headers, result).Execute(method, client.host+path)
+		})
 	}
-	if tagsErr != nil {
+	if err != nil {
 		if client.log != nil {
-			client.log.Error("failed to retrieve tags", tagsErr, tagsGetResp)
+			client.log.Error("failed to query registry", path, err)
 		}
-		return nil, tagsErr
+		return nil, err
 	}
-	if tagsGetResp.StatusCode() != http.StatusOK {
+	if rErr := responseError(resp); rErr != nil {
 		if client.log != nil {
-			client.log.Error("unexpected http code returned", tagsGetResp.StatusCode(), string(tagsGetResp.Body()))
+			client.log.Error("unexpected http code returned", path, resp.StatusCode(), string(resp.Body()))
 		}
-		return nil, errors.New("unexpected http code returned")
+		return nil, rErr
 	}
+	return resp, nil
+}
 
-	return tagsResponse.Tags, nil
+func (client *Client) served(endpoint, path string) {
+	if client.recorder != nil {
+		client.recorder(endpoint, path)
+	}
+}
+
+// request - upstream registry request with current token
+func (client *Client) request(headers map[string]string, result interface{}) *resty.Request {
+	request := client.anonymousRequest(headers, result)
+	if token := client.currentToken(); token != "" {
+		request = request.SetHeader("Authorization", "Bearer "+token)
+	}
+	return request
+}
+
+// anonymousRequest - request without the upstream token (e.g. to a mirror)
+func (client *Client) anonymousRequest(headers map[string]string, result interface{}) *resty.Request {
+	request := client.httpClient.R()
+	if headers != nil {
+		request = request.SetHeaders(headers)
+	}
+	if result != nil {
+		request = request.SetResult(result).ForceContentType("application/json")
+	}
+	return request
+}
+
+func (client *Client) currentToken() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.token
+}
+
+func (client *Client) refreshAuthToken(repository string) error {
+	token, err := client.retrieveAuthToken(repository)
+	if err != nil {
+		return err
+	}
+	client.mutex.Lock()
+	client.token = token.Token
+	client.mutex.Unlock()
+	return nil
 }
 
 func (client *Client) retrieveAuthToken(repository string) (*authResponse, error) {
 	scope := getScope(repository)
-	tokenURL := authURL + "?service=" + serviceURL + "&" + "scope=" + scope + "&offline_token=1&client_id=microkit-plugins-1.0"
+	tokenURL := client.authURL + "?service=" + serviceURL + "&" + "scope=" + scope + "&offline_token=1&client_id=microkit-plugins-1.0"
 	var authResponse authResponse
-	request := client.httpClient.R()
-	if client.username != "" && client.password != "" {
-		request = request.SetBasicAuth(client.username, client.password)
-	}
-	request = request.SetResult(&authResponse)
-	tokenResp, tokenErr := request.Get(tokenURL)
+	tokenResp, tokenErr := client.retry.do(client.log, func() (*resty.Response, error) {
+		request := client.httpClient.R()
+		if client.username != "" && client.password != "" {
+			request = request.SetBasicAuth(client.username, client.password)
+		}
+		return request.SetResult(&authResponse).ForceContentType("application/json").Get(tokenURL)
+	})
 	if tokenErr != nil {
 		if client.log != nil {
 			client.log.Error("failed to get authentication token", tokenErr)
-			return nil, errors.New("Unauthirized")
 		}
+		// network failure, not a credentials problem (401/403 are mapped to ErrUnauthorized by responseError)
+		return nil, tokenErr
 	}
-	if tokenResp.StatusCode() != http.StatusOK {
+	if err := responseError(tokenResp); err != nil {
 		if client.log != nil {
-			client.log.Error("failed to retrieve auth token", tokenResp)
-			return nil, errors.New("failed to retrieve auth token")
+			client.log.Error("failed to retrieve auth token", tokenResp.StatusCode(), string(tokenResp.Body()))
 		}
+		return nil, err
 	}
 	return &authResponse, nil
 }
@@ -186,6 +374,21 @@ func getScope(repository string) string {
 	return "repository:" + repository + ":pull"
 }
 
+func trimMirrors(mirrors []string) []string {
+	out := make([]string, 0, len(mirrors))
+	for _, m := range mirrors {
+		m = strings.TrimSuffix(strings.TrimSpace(m), "/")
+		if m == "" {
+			continue
+		}
+		if !strings.HasPrefix(m, "http://") && !strings.HasPrefix(m, "https://") {
+			m = "https://" + m
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
 func slashFirstSlash(repository string) string {
 	// remove first slash if exists in repository
 	if strings.HasPrefix(repository, "/") {
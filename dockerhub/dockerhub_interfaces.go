@@ -4,3 +4,30 @@ package dockerhub
 type DockerHub interface {
 	Tags(repostiory string) ([]string, error)
 }
+
+// RegistryClient registry API beyond tags, implemented by the client returned from NewClient:
+//
+//	registry := dockerhub.NewClient().(dockerhub.RegistryClient)
+type RegistryClient interface {
+	DockerHub
+
+	// Digest returns the manifest digest of the tag
+	Digest(repository, tag string) (string, error)
+
+	// Manifest returns raw manifest and its media type
+	Manifest(repository, reference string) ([]byte, string, error)
+
+	// Blob returns content of the blob
+	Blob(repository, digest string) ([]byte, error)
+
+	// RateLimit returns remaining pull quota
+	RateLimit() (*RateLimitStatus, error)
+}
+
+// Hub hub.docker.com REST API (repository metadata)
+type Hub interface {
+	SearchRepositories(query string, page, pageSize int) (*RepositorySearchPage, error)
+	Repository(repository string) (*Repository, error)
+	RepositoryTags(repository string, page, pageSize int) (*TagsPage, error)
+	Tag(repository, tag string) (*Tag, error)
+}
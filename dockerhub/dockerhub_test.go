@@ -1,7 +1,13 @@
 package dockerhub
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	mclog "github.com/chryscloud/go-microkit-plugins/log"
 )
@@ -21,3 +27,174 @@ func TestInit(t *testing.T) {
 		t.Fatalf("expected more than 0 repositories, got %v", len(tags))
 	}
 }
+
+func newRegistryTestServer(t *testing.T, tagsHandler http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(authResponse{Token: "registrytoken"})
+	})
+	mux.HandleFunc("/v2/chryscloud/chrysedgeproxy/tags/list", tagsHandler)
+	mux.HandleFunc("/v2/ratelimitpreview/test/manifests/latest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Fatalf("expected HEAD request, got %v", r.Method)
+		}
+		w.Header().Set("ratelimit-limit", "100;w=21600")
+		w.Header().Set("ratelimit-remaining", "76;w=21600")
+		w.Header().Set("docker-ratelimit-source", "127.0.0.1")
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestTagsTypedErrors(t *testing.T) {
+	srv := newRegistryTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ratelimit-limit", "100;w=21600")
+		w.Header().Set("ratelimit-remaining", "0;w=21600")
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer srv.Close()
+
+	cl := NewClient(Host(srv.URL), AuthURL(srv.URL+"/token"))
+	_, err := cl.Tags("chryscloud/chrysedgeproxy")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected rate limited error, got %v", err)
+	}
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) || rlErr.Limit != 100 || rlErr.Remaining != 0 {
+		t.Fatalf("unexpected rate limit error %v", err)
+	}
+	if rlErr.Reset.Before(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("expected reset in an hour, got %v", rlErr.Reset)
+	}
+
+	_, err = cl.Tags("chryscloud/notexisting")
+	if err != ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestTagsRetry(t *testing.T) {
+	var calls int32
+	srv := newRegistryTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(tagsResponse{Name: "chryscloud/chrysedgeproxy", Tags: []string{"0.0.1", "0.0.2"}})
+	})
+	defer srv.Close()
+
+	cl := NewClient(Host(srv.URL), AuthURL(srv.URL+"/token"), Retry(3, time.Millisecond, 10*time.Millisecond))
+	tags, err := cl.Tags("chryscloud/chrysedgeproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || calls != 3 {
+		t.Fatalf("expected 2 tags after 3 calls, got %v tags after %v calls", len(tags), calls)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := newRegistryTestServer(t, http.NotFound)
+	defer srv.Close()
+
+	cl := NewClient(Host(srv.URL), AuthURL(srv.URL+"/token")).(RegistryClient)
+	status, err := cl.RateLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Limit != 100 || status.Remaining != 76 || status.Window != 6*time.Hour || status.Source != "127.0.0.1" {
+		t.Fatalf("unexpected rate limit status %+v", status)
+	}
+}
+
+func TestTagsMirrorFallback(t *testing.T) {
+	upstream := newRegistryTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tagsResponse{Name: "chryscloud/chrysedgeproxy", Tags: []string{"0.0.1"}})
+	})
+	defer upstream.Close()
+	brokenMirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer brokenMirror.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/chryscloud/chrysedgeproxy/tags/list" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(tagsResponse{Name: "chryscloud/chrysedgeproxy", Tags: []string{"0.0.1", "0.0.2"}})
+	}))
+	defer mirror.Close()
+
+	var served []string
+	recorder := func(endpoint, path string) {
+		served = append(served, endpoint)
+	}
+	cl := NewClient(Host(upstream.URL), AuthURL(upstream.URL+"/token"), Mirrors(brokenMirror.URL, mirror.URL+"/"), EndpointRecorder(recorder))
+	tags, err := cl.Tags("chryscloud/chrysedgeproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 {
+		t.Fatalf("expected tags from mirror, got %v", tags)
+	}
+
+	cl = NewClient(Host(upstream.URL), AuthURL(upstream.URL+"/token"), Mirrors(brokenMirror.URL), EndpointRecorder(recorder))
+	tags, err = cl.Tags("chryscloud/chrysedgeproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 {
+		t.Fatalf("expected tags from upstream, got %v", tags)
+	}
+	if len(served) != 2 || served[0] != mirror.URL || served[1] != upstream.URL {
+		t.Fatalf("unexpected served endpoints %v", served)
+	}
+}
+
+func TestMirrorWithoutUpstreamToken(t *testing.T) {
+	upstream := newRegistryTestServer(t, http.NotFound)
+	defer upstream.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("upstream token sent to mirror: %v", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path != "/v2/chryscloud/chrysedgeproxy/tags/list" {
+			// mirrors answer without docker hub quota headers
+			return
+		}
+		json.NewEncoder(w).Encode(tagsResponse{Name: "chryscloud/chrysedgeproxy", Tags: []string{"0.0.1"}})
+	}))
+	defer mirror.Close()
+
+	cl := NewClient(Host(upstream.URL), AuthURL(upstream.URL+"/token"), Mirrors(mirror.URL)).(RegistryClient)
+	status, err := cl.RateLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Limit != 100 || status.Remaining != 76 {
+		t.Fatalf("expected docker hub quota, got %+v", status)
+	}
+	if _, err := cl.Tags("chryscloud/chrysedgeproxy"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthTokenErrors(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	cl := NewClient(Host(down.URL), AuthURL(down.URL+"/token"), Retry(0, time.Millisecond, time.Millisecond))
+	if _, err := cl.Tags("chryscloud/chrysedgeproxy"); err == nil || errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected network error, got %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	cl = NewClient(Host(srv.URL), AuthURL(srv.URL+"/token"), Credentials("user", "wrong"))
+	if _, err := cl.Tags("chryscloud/chrysedgeproxy"); err != ErrUnauthorized {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package dockerhub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	// ErrUnauthorized when credentials are missing, wrong or have no access to the repository
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound when repository, tag or manifest doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrRateLimited when docker hub pull rate limit is exceeded (check RateLimitError for details)
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrUnexpectedStatus any other non successful http code
+	ErrUnexpectedStatus = errors.New("unexpected http code returned")
+	// ErrRegistryClient when digests are resolved with a hub that doesn't implement RegistryClient
+	ErrRegistryClient = errors.New("hub doesn't implement RegistryClient")
+)
+
+// RateLimitError returned on http 429. errors.Is(err, ErrRateLimited) is true for this error
+type RateLimitError struct {
+	Limit     int       // pull limit within the window (-1 if unknown)
+	Remaining int       // remaining pulls within the window (-1 if unknown)
+	Reset     time.Time // earliest time when request should be retried
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v (limit %d, remaining %d, reset at %v)", ErrRateLimited, e.Limit, e.Remaining, e.Reset.Format(time.RFC3339))
+}
+
+// Is - matches ErrRateLimited
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// responseError maps non successful http responses to typed errors
+func responseError(resp *resty.Response) error {
+	switch code := resp.StatusCode(); {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrUnauthorized
+	case code == http.StatusNotFound:
+		return ErrNotFound
+	case code == http.StatusTooManyRequests:
+		limit, window := parseRateLimitHeader(resp.Header().Get("ratelimit-limit"))
+		remaining, _ := parseRateLimitHeader(resp.Header().Get("ratelimit-remaining"))
+		reset, ok := retryAfter(resp, time.Now())
+		if !ok {
+			reset = time.Now().Add(window)
+		}
+		return &RateLimitError{Limit: limit, Remaining: remaining, Reset: reset}
+	default:
+		return ErrUnexpectedStatus
+	}
+}
+
+// parseRateLimitHeader parses docker hub rate limit header format, e.g. 100;w=21600. Returns -1 if header missing or malformed
+func parseRateLimitHeader(value string) (int, time.Duration) {
+	parts := strings.Split(value, ";")
+	count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return -1, 0
+	}
+	var window time.Duration
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "w=") {
+			if sec, err := strconv.Atoi(p[2:]); err == nil {
+				window = time.Duration(sec) * time.Second
+			}
+		}
+	}
+	return count, window
+}
+
+// retryAfter reads Retry-After header (either delay in seconds or http date)
+func retryAfter(resp *resty.Response, now time.Time) (time.Time, bool) {
+	value := resp.Header().Get("Retry-After")
+	if value == "" {
+		return time.Time{}, false
+	}
+	if sec, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(sec) * time.Second), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
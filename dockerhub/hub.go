@@ -0,0 +1,296 @@
+package dockerhub
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	hubURL = "https://hub.docker.com"
+
+	// ErrHubLogin when hub.docker.com login fails (e.g. wrong credentials)
+	ErrHubLogin = errors.New("failed to login to docker hub")
+)
+
+const (
+	defaultHubPageSize = 25
+	maxHubPageSize     = 100
+)
+
+// Repository - docker hub repository metadata
+type Repository struct {
+	User            string    `json:"user"`
+	Name            string    `json:"name"`
+	Namespace       string    `json:"namespace"`
+	RepositoryType  string    `json:"repository_type"`
+	Status          int       `json:"status"`
+	Description     string    `json:"description"`
+	FullDescription string    `json:"full_description"`
+	IsPrivate       bool      `json:"is_private"`
+	IsAutomated     bool      `json:"is_automated"`
+	StarCount       int64     `json:"star_count"`
+	PullCount       int64     `json:"pull_count"`
+	LastUpdated     time.Time `json:"last_updated"`
+	DateRegistered  time.Time `json:"date_registered"`
+}
+
+// RepositorySearchResult - a single result of a repository search
+type RepositorySearchResult struct {
+	RepoName         string `json:"repo_name"`
+	ShortDescription string `json:"short_description"`
+	StarCount        int64  `json:"star_count"`
+	PullCount        int64  `json:"pull_count"`
+	RepoOwner        string `json:"repo_owner"`
+	IsAutomated      bool   `json:"is_automated"`
+	IsOfficial       bool   `json:"is_official"`
+}
+
+// RepositorySearchPage - one page of repository search results
+type RepositorySearchPage struct {
+	Count    int                      `json:"count"`
+	Next     string                   `json:"next"`
+	Previous string                   `json:"previous"`
+	Results  []RepositorySearchResult `json:"results"`
+}
+
+// TagImage - per architecture image of a tag
+type TagImage struct {
+	Architecture string    `json:"architecture"`
+	Features     string    `json:"features"`
+	Variant      string    `json:"variant"`
+	Digest       string    `json:"digest"`
+	OS           string    `json:"os"`
+	OSVersion    string    `json:"os_version"`
+	Size         int64     `json:"size"`
+	Status       string    `json:"status"`
+	LastPulled   time.Time `json:"last_pulled"`
+	LastPushed   time.Time `json:"last_pushed"`
+}
+
+// Tag - docker hub tag details
+type Tag struct {
+	Name                string     `json:"name"`
+	FullSize            int64      `json:"full_size"`
+	Digest              string     `json:"digest"`
+	LastUpdated         time.Time  `json:"last_updated"`
+	LastUpdaterUsername string     `json:"last_updater_username"`
+	TagStatus           string     `json:"tag_status"`
+	TagLastPulled       time.Time  `json:"tag_last_pulled"`
+	TagLastPushed       time.Time  `json:"tag_last_pushed"`
+	Images              []TagImage `json:"images"`
+}
+
+// TagsPage - one page of repository tags
+type TagsPage struct {
+	Count    int    `json:"count"`
+	Next     string `json:"next"`
+	Previous string `json:"previous"`
+	Results  []Tag  `json:"results"`
+}
+
+// HasNext - true if there are more pages available
+func (p *RepositorySearchPage) HasNext() bool {
+	return p.Next != ""
+}
+
+// HasNext - true if there are more pages available
+func (p *TagsPage) HasNext() bool {
+	return p.Next != ""
+}
+
+type hubLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type hubLoginResponse struct {
+	Token string `json:"token"`
+}
+
+// HubClient - hub.docker.com REST API abstraction (repository metadata)
+type HubClient struct {
+	host       string
+	log        mclog.Logger
+	httpClient *resty.Client
+	retry      retryPolicy
+	username   string
+	password   string
+	token      string
+	mutex      *sync.Mutex
+}
+
+// NewHubClient new client for hub.docker.com v2 API. Host option overrides the default https://hub.docker.com.
+// With Credentials option the client logs in and uses JWT for authorization (required for private repositories)
+func NewHubClient(opts ...Option) Hub {
+	args := &Options{}
+	for _, op := range opts {
+		if op != nil {
+			op(args)
+		}
+	}
+	if args.Host == "" {
+		args.Host = hubURL
+	}
+	cl := resty.New().SetHeader("Content-Type", "application/json")
+	cl.Debug = false
+
+	return &HubClient{
+		host:       args.Host,
+		log:        args.Log,
+		httpClient: cl,
+		retry:      newRetryPolicy(args),
+		username:   args.username,
+		password:   args.password,
+		mutex:      &sync.Mutex{},
+	}
+}
+
+// SearchRepositories - search public repositories. Page starts with 1, pageSize max 100
+func (client *HubClient) SearchRepositories(query string, page, pageSize int) (*RepositorySearchPage, error) {
+	var result RepositorySearchPage
+	params := pageParams(page, pageSize)
+	params.Set("query", query)
+	err := client.get("/v2/search/repositories/", params, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Repository - repository details (description, pull count, last updated, ...)
+func (client *HubClient) Repository(repository string) (*Repository, error) {
+	var result Repository
+	err := client.get("/v2/repositories/"+hubRepository(repository)+"/", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RepositoryTags - one page of tags with architectures and sizes. Page starts with 1, pageSize max 100
+func (client *HubClient) RepositoryTags(repository string, page, pageSize int) (*TagsPage, error) {
+	var result TagsPage
+	err := client.get("/v2/repositories/"+hubRepository(repository)+"/tags/", pageParams(page, pageSize), &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Tag - single tag details
+func (client *HubClient) Tag(repository, tag string) (*Tag, error) {
+	var result Tag
+	err := client.get("/v2/repositories/"+hubRepository(repository)+"/tags/"+url.PathEscape(tag)+"/", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client *HubClient) get(path string, params url.Values, result interface{}) error {
+	if client.username != "" && client.currentToken() == "" {
+		if err := client.login(); err != nil {
+			return err
+		}
+	}
+
+	get := func() (*resty.Response, error) {
+		return client.request(params, result).Get(client.host + path)
+	}
+	resp, err := client.retry.do(client.log, get)
+	if err == nil && resp.StatusCode() == http.StatusUnauthorized && client.username != "" {
+		// token might have expired, login and try once more
+		if lErr := client.login(); lErr != nil {
+			return lErr
+		}
+		resp, err = client.retry.do(client.log, get)
+	}
+	if err != nil {
+		if client.log != nil {
+			client.log.Error("failed to query docker hub", path, err)
+		}
+		return err
+	}
+	if rErr := responseError(resp); rErr != nil {
+		if client.log != nil {
+			client.log.Error("unexpected http code returned", path, resp.StatusCode(), string(resp.Body()))
+		}
+		return rErr
+	}
+	return nil
+}
+
+func (client *HubClient) request(params url.Values, result interface{}) *resty.Request {
+	request := client.httpClient.R().SetResult(result).ForceContentType("application/json")
+	if params != nil {
+		request = request.SetQueryParamsFromValues(params)
+	}
+	if token := client.currentToken(); token != "" {
+		request = request.SetHeader("Authorization", "Bearer "+token)
+	}
+	return request
+}
+
+func (client *HubClient) login() error {
+	var loginResponse hubLoginResponse
+	resp, err := client.httpClient.R().
+		SetBody(&hubLoginRequest{Username: client.username, Password: client.password}).
+		SetResult(&loginResponse).
+		ForceContentType("application/json").
+		Post(client.host + "/v2/users/login/")
+	if err != nil {
+		if client.log != nil {
+			client.log.Error("failed to login to docker hub", err)
+		}
+		return ErrHubLogin
+	}
+	if resp.StatusCode() != http.StatusOK || loginResponse.Token == "" {
+		if client.log != nil {
+			client.log.Error("failed to login to docker hub", resp.StatusCode(), string(resp.Body()))
+		}
+		return ErrHubLogin
+	}
+	client.mutex.Lock()
+	client.token = loginResponse.Token
+	client.mutex.Unlock()
+	return nil
+}
+
+func (client *HubClient) currentToken() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.token
+}
+
+func pageParams(page, pageSize int) url.Values {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = defaultHubPageSize
+	}
+	if pageSize > maxHubPageSize {
+		pageSize = maxHubPageSize
+	}
+	params := url.Values{}
+	params.Set("page", strconv.Itoa(page))
+	params.Set("page_size", strconv.Itoa(pageSize))
+	return params
+}
+
+// official images live in the library namespace (e.g. nginx -> library/nginx)
+func hubRepository(repository string) string {
+	repository = slashFirstSlash(repository)
+	if !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return repository
+}
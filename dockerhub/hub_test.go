@@ -0,0 +1,81 @@
+package dockerhub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newHubTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/users/login/", func(w http.ResponseWriter, r *http.Request) {
+		var req hubLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Username != "user" || req.Password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(hubLoginResponse{Token: "hubtoken"})
+	})
+	mux.HandleFunc("/v2/repositories/chryscloud/chrysedgeproxy/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hubtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Repository{Name: "chrysedgeproxy", Namespace: "chryscloud", PullCount: 42})
+	})
+	mux.HandleFunc("/v2/repositories/library/nginx/tags/", func(w http.ResponseWriter, r *http.Request) {
+		page := TagsPage{Count: 2}
+		if r.URL.Query().Get("page") == "1" {
+			page.Next = "next"
+			page.Results = []Tag{{Name: "latest", Images: []TagImage{{Architecture: "amd64", Size: 100}}}}
+		} else {
+			page.Results = []Tag{{Name: "1.19"}}
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestHubRepositoryWithLogin(t *testing.T) {
+	srv := newHubTestServer(t)
+	defer srv.Close()
+
+	cl := NewHubClient(Host(srv.URL), Log(zl), Credentials("user", "pass"))
+	repo, err := cl.Repository("chryscloud/chrysedgeproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.PullCount != 42 {
+		t.Fatalf("expected pull count 42, got %v", repo.PullCount)
+	}
+
+	cl = NewHubClient(Host(srv.URL), Credentials("user", "wrong"))
+	if _, err := cl.Repository("chryscloud/chrysedgeproxy"); err != ErrHubLogin {
+		t.Fatalf("expected login error, got %v", err)
+	}
+}
+
+func TestHubRepositoryTagsPagination(t *testing.T) {
+	srv := newHubTestServer(t)
+	defer srv.Close()
+
+	cl := NewHubClient(Host(srv.URL))
+	page, err := cl.RepositoryTags("nginx", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.HasNext() || len(page.Results) != 1 || page.Results[0].Images[0].Architecture != "amd64" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page, err = cl.RepositoryTags("nginx", 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.HasNext() || page.Results[0].Name != "1.19" {
+		t.Fatalf("unexpected second page %+v", page)
+	}
+}
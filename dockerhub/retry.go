@@ -0,0 +1,79 @@
+package dockerhub
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+	"github.com/go-resty/resty/v2"
+)
+
+// retryPolicy exponential backoff with full jitter. Retry-After header has precedence over computed backoff
+type retryPolicy struct {
+	maxRetries int
+	minWait    time.Duration
+	maxWait    time.Duration
+}
+
+func newRetryPolicy(args *Options) retryPolicy {
+	policy := retryPolicy{
+		maxRetries: args.MaxRetries,
+		minWait:    args.RetryMinWait,
+		maxWait:    args.RetryMaxWait,
+	}
+	if policy.minWait <= 0 {
+		policy.minWait = defaultRetryMinWait
+	}
+	if policy.maxWait < policy.minWait {
+		policy.maxWait = defaultRetryMaxWait
+	}
+	return policy
+}
+
+// do executes request until it succeeds, fails with non retryable status or retries are exhausted
+func (p retryPolicy) do(log mclog.Logger, request func() (*resty.Response, error)) (*resty.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := request()
+		if attempt >= p.maxRetries || !retryable(resp, err) {
+			return resp, err
+		}
+		wait := p.backoff(attempt)
+		if err == nil {
+			if until, ok := retryAfter(resp, time.Now()); ok {
+				wait = time.Until(until)
+				if wait > p.maxWait {
+					// not worth waiting, let the caller decide
+					return resp, err
+				}
+			}
+		}
+		if log != nil {
+			log.Warn("retrying docker hub request", "attempt", attempt+1, "wait", wait, "error", err)
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	wait := p.minWait << uint(attempt)
+	if wait > p.maxWait || wait <= 0 {
+		wait = p.maxWait
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(wait)) + 1)
+}
+
+// network errors, rate limiting and temporary server errors are retryable
+func retryable(resp *resty.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode() {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,518 @@
+package dockerhub
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	mclog "github.com/chryscloud/go-microkit-plugins/log"
+)
+
+// EventType type of the tag change
+type EventType string
+
+const (
+	// TagCreated new tag pushed to the repository
+	TagCreated EventType = "tag_created"
+	// TagMoved existing tag points to a new digest (e.g. latest repointed)
+	TagMoved EventType = "tag_moved"
+	// TagDeleted tag removed from the repository
+	TagDeleted EventType = "tag_deleted"
+)
+
+const (
+	defaultWatchInterval = 5 * time.Minute
+	defaultWatchBuffer   = 100
+)
+
+// TagEvent emitted to subscribers of the Watcher
+type TagEvent struct {
+	Type           EventType `json:"type"`
+	Repository     string    `json:"repository"`
+	Tag            string    `json:"tag"`
+	Digest         string    `json:"digest,omitempty"`          // empty for deleted tags or if digests are not resolved
+	PreviousDigest string    `json:"previous_digest,omitempty"` // set for moved and deleted tags
+	Time           time.Time `json:"time"`
+}
+
+// TagStore persists last seen tags (tag -> digest) per repository
+type TagStore interface {
+	// Load returns last seen tags and false if repository was never saved before
+	Load(repository string) (map[string]string, bool, error)
+	Save(repository string, tags map[string]string) error
+}
+
+// WatcherOptions settings of the tag watcher
+type WatcherOptions struct {
+	Log            mclog.Logger
+	Interval       time.Duration
+	Store          TagStore
+	ResolveDigests bool
+	BufferSize     int
+}
+
+// WatcherOption a single watcher option
+type WatcherOption func(*WatcherOptions)
+
+// WatchLog - recommended but optional
+func WatchLog(log mclog.Logger) WatcherOption {
+	return func(args *WatcherOptions) {
+		args.Log = log
+	}
+}
+
+// WatchInterval - polling interval, default 5 minutes
+func WatchInterval(interval time.Duration) WatcherOption {
+	return func(args *WatcherOptions) {
+		args.Interval = interval
+	}
+}
+
+// WatchStore - where last seen tags are persisted, default in memory
+func WatchStore(store TagStore) WatcherOption {
+	return func(args *WatcherOptions) {
+		args.Store = store
+	}
+}
+
+// WatchDigests - resolves digest of every watched tag on each poll to detect when a mutable tag (e.g. latest) is repointed.
+// Each resolved tag costs one HEAD request, so prefer watching explicit tags with this mode enabled
+func WatchDigests(enabled bool) WatcherOption {
+	return func(args *WatcherOptions) {
+		args.ResolveDigests = enabled
+	}
+}
+
+// WatchBuffer - size of the subscriber channel, default 100
+func WatchBuffer(size int) WatcherOption {
+	return func(args *WatcherOptions) {
+		args.BufferSize = size
+	}
+}
+
+type subscriber struct {
+	events chan TagEvent
+	quit   chan struct{}
+}
+
+// Watcher polls repositories and emits events when tags are created, moved or deleted
+type Watcher struct {
+	hub            DockerHub
+	log            mclog.Logger
+	interval       time.Duration
+	store          TagStore
+	resolveDigests bool
+	bufferSize     int
+
+	mutex        sync.Mutex
+	pollMutex    sync.Mutex
+	repositories map[string][]string // repository -> watched tags (empty for all tags)
+	polled       map[string][]string // repository -> watched tags of the last saved poll (guarded by pollMutex)
+	subscribers  []*subscriber
+	done         chan struct{}
+	stopped      chan struct{}
+	running      bool
+	closed       bool
+}
+
+// NewWatcher creates a tag watcher. Call Start to begin polling
+func NewWatcher(hub DockerHub, opts ...WatcherOption) *Watcher {
+	args := &WatcherOptions{
+		Interval:   defaultWatchInterval,
+		BufferSize: defaultWatchBuffer,
+	}
+	for _, op := range opts {
+		if op != nil {
+			op(args)
+		}
+	}
+	if args.Store == nil {
+		args.Store = NewMemoryTagStore()
+	}
+	return &Watcher{
+		hub:            hub,
+		log:            args.Log,
+		interval:       args.Interval,
+		store:          args.Store,
+		resolveDigests: args.ResolveDigests,
+		bufferSize:     args.BufferSize,
+		repositories:   make(map[string][]string),
+		polled:         make(map[string][]string),
+		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+}
+
+// Watch adds repository to the watch list. If no tags are given all tags of the repository are watched.
+// First poll of a repository without previously stored state only records the baseline (no events emitted).
+// Calling Watch again with different tags emits no events for tags that are no longer or newly watched
+func (w *Watcher) Watch(repository string, tags ...string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.repositories[slashFirstSlash(repository)] = tags
+}
+
+// Unwatch removes repository from the watch list
+func (w *Watcher) Unwatch(repository string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.repositories, slashFirstSlash(repository))
+}
+
+// Subscribe returns a channel of tag events. Channel is closed on Stop.
+// Slow subscribers slow down polling since events are never dropped
+func (w *Watcher) Subscribe() <-chan TagEvent {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	sub := &subscriber{
+		events: make(chan TagEvent, w.bufferSize),
+		quit:   make(chan struct{}),
+	}
+	if w.closed {
+		close(sub.events)
+		return sub.events
+	}
+	w.subscribers = append(w.subscribers, sub)
+	return sub.events
+}
+
+// Unsubscribe stops delivering events to the channel
+func (w *Watcher) Unsubscribe(events <-chan TagEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for i, sub := range w.subscribers {
+		if sub.events == events {
+			close(sub.quit)
+			w.subscribers = append(w.subscribers[:i], w.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Start polling in the background (first poll immediately)
+func (w *Watcher) Start() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.running || w.closed {
+		return
+	}
+	w.running = true
+	go w.run()
+}
+
+// Stop polling and close all subscriber channels
+func (w *Watcher) Stop() {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return
+	}
+	w.closed = true
+	running := w.running
+	close(w.done)
+	w.mutex.Unlock()
+
+	if running {
+		<-w.stopped
+	}
+
+	// no publishing possible after poll mutex is acquired
+	w.pollMutex.Lock()
+	defer w.pollMutex.Unlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, sub := range w.subscribers {
+		close(sub.events)
+	}
+	w.subscribers = nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		// errors are logged per repository
+		w.Poll()
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Poll checks all watched repositories once and emits events. Returns the last error encountered
+func (w *Watcher) Poll() error {
+	w.pollMutex.Lock()
+	defer w.pollMutex.Unlock()
+
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	repositories := make(map[string][]string, len(w.repositories))
+	for repository, tags := range w.repositories {
+		repositories[repository] = tags
+	}
+	w.mutex.Unlock()
+
+	var lastErr error
+	for repository, tags := range repositories {
+		if err := w.pollRepository(repository, tags); err != nil {
+			if w.log != nil {
+				w.log.Error("failed to check tags of repository", repository, err)
+			}
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (w *Watcher) pollRepository(repository string, watched []string) error {
+	all, err := w.hub.Tags(repository)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]string)
+	if len(watched) == 0 {
+		for _, tag := range all {
+			current[tag] = ""
+		}
+	} else {
+		existing := make(map[string]bool, len(all))
+		for _, tag := range all {
+			existing[tag] = true
+		}
+		for _, tag := range watched {
+			if existing[tag] {
+				current[tag] = ""
+			}
+		}
+	}
+	if w.resolveDigests {
+		registry, ok := w.hub.(RegistryClient)
+		if !ok {
+			return ErrRegistryClient
+		}
+		for tag := range current {
+			digest, dErr := registry.Digest(repository, tag)
+			if errors.Is(dErr, ErrNotFound) {
+				// deleted in between listing tags and resolving digest
+				delete(current, tag)
+				continue
+			}
+			if dErr != nil {
+				return dErr
+			}
+			current[tag] = digest
+		}
+	}
+
+	previous, found, err := w.store.Load(repository)
+	if err != nil {
+		return err
+	}
+	if found {
+		previous = w.watchedBefore(repository, watched, previous, current)
+		// tags are saved only after all events were delivered, otherwise they are reported again on next poll (at-least-once)
+		for _, event := range diffTags(repository, previous, current, time.Now()) {
+			if !w.publish(event) {
+				return nil
+			}
+		}
+	}
+	if err := w.store.Save(repository, current); err != nil {
+		return err
+	}
+	w.polled[repository] = watched
+	return nil
+}
+
+// watchedBefore restricts previous tags to tags watched in both the last and this poll. State of tags no longer watched is dropped
+// and newly watched tags are taken as they are now, so changing the watch list doesn't emit events
+func (w *Watcher) watchedBefore(repository string, watched []string, previous, current map[string]string) map[string]string {
+	lastWatched, known := w.polled[repository]
+	restricted := make(map[string]string, len(previous))
+	for tag, digest := range previous {
+		if isWatched(watched, tag) {
+			restricted[tag] = digest
+		}
+	}
+	if known {
+		for tag, digest := range current {
+			if !isWatched(lastWatched, tag) {
+				restricted[tag] = digest
+			}
+		}
+	}
+	return restricted
+}
+
+// isWatched true if tag is in watched tags or all tags are watched
+func isWatched(watched []string, tag string) bool {
+	if len(watched) == 0 {
+		return true
+	}
+	for _, t := range watched {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// diffTags returns events sorted by tag name. Moved tags are detected only if both digests are known
+func diffTags(repository string, previous, current map[string]string, now time.Time) []TagEvent {
+	events := make([]TagEvent, 0)
+	for tag, digest := range current {
+		prevDigest, ok := previous[tag]
+		if !ok {
+			events = append(events, TagEvent{Type: TagCreated, Repository: repository, Tag: tag, Digest: digest, Time: now})
+		} else if prevDigest != "" && digest != "" && prevDigest != digest {
+			events = append(events, TagEvent{Type: TagMoved, Repository: repository, Tag: tag, Digest: digest, PreviousDigest: prevDigest, Time: now})
+		}
+	}
+	for tag, prevDigest := range previous {
+		if _, ok := current[tag]; !ok {
+			events = append(events, TagEvent{Type: TagDeleted, Repository: repository, Tag: tag, PreviousDigest: prevDigest, Time: now})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Tag < events[j].Tag
+	})
+	return events
+}
+
+// publish delivers event to all subscribers, false if watcher was stopped meanwhile
+func (w *Watcher) publish(event TagEvent) bool {
+	w.mutex.Lock()
+	subscribers := make([]*subscriber, len(w.subscribers))
+	copy(subscribers, w.subscribers)
+	w.mutex.Unlock()
+
+	for _, sub := range subscribers {
+		select {
+		case sub.events <- event:
+		case <-sub.quit:
+		case <-w.done:
+			return false
+		}
+	}
+	return true
+}
+
+// MemoryTagStore keeps last seen tags in memory (lost on restart)
+type MemoryTagStore struct {
+	mutex sync.Mutex
+	tags  map[string]map[string]string
+}
+
+// NewMemoryTagStore in memory tag store
+func NewMemoryTagStore() *MemoryTagStore {
+	return &MemoryTagStore{
+		tags: make(map[string]map[string]string),
+	}
+}
+
+// Load last seen tags of the repository
+func (s *MemoryTagStore) Load(repository string) (map[string]string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tags, ok := s.tags[repository]
+	return copyTags(tags), ok, nil
+}
+
+// Save last seen tags of the repository
+func (s *MemoryTagStore) Save(repository string, tags map[string]string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tags[repository] = copyTags(tags)
+	return nil
+}
+
+// FileTagStore persists last seen tags of all repositories into a single JSON file
+type FileTagStore struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// NewFileTagStore JSON file tag store. File is created on first save
+func NewFileTagStore(path string) *FileTagStore {
+	return &FileTagStore{path: path}
+}
+
+// Load last seen tags of the repository
+func (s *FileTagStore) Load(repository string) (map[string]string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	all, err := s.read()
+	if err != nil {
+		return nil, false, err
+	}
+	tags, ok := all[repository]
+	return tags, ok, nil
+}
+
+// Save last seen tags of the repository
+func (s *FileTagStore) Save(repository string, tags map[string]string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	all[repository] = copyTags(tags)
+	content, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to temp file and rename to prevent corrupted state on crash
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileTagStore) read() (map[string]map[string]string, error) {
+	all := make(map[string]map[string]string)
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	out := make(map[string]string, len(tags))
+	for tag, digest := range tags {
+		out[tag] = digest
+	}
+	return out
+}
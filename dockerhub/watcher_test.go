@@ -0,0 +1,229 @@
+package dockerhub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeHub struct {
+	mutex sync.Mutex
+	tags  map[string]string // tag -> digest
+}
+
+func (h *fakeHub) set(tag, digest string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if digest == "" {
+		delete(h.tags, tag)
+		return
+	}
+	h.tags[tag] = digest
+}
+
+func (h *fakeHub) Tags(repository string) ([]string, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	tags := make([]string, 0)
+	for tag := range h.tags {
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (h *fakeHub) Digest(repository, tag string) (string, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	digest, ok := h.tags[tag]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return digest, nil
+}
+
+func (h *fakeHub) Manifest(repository, reference string) ([]byte, string, error) {
+	return nil, "", ErrNotFound
+}
+
+func (h *fakeHub) Blob(repository, digest string) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+func (h *fakeHub) RateLimit() (*RateLimitStatus, error) {
+	return &RateLimitStatus{Limit: -1, Remaining: -1}, nil
+}
+
+func TestWatcherEvents(t *testing.T) {
+	hub := &fakeHub{tags: map[string]string{"latest": "sha256:1", "0.0.1": "sha256:1"}}
+	w := NewWatcher(hub, WatchDigests(true), WatchLog(zl))
+	w.Watch("chryscloud/chrysedgeproxy")
+	events := w.Subscribe()
+
+	// baseline
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events on baseline poll, got %v", len(events))
+	}
+
+	hub.set("0.0.2", "sha256:2")
+	hub.set("latest", "sha256:2")
+	hub.set("0.0.1", "")
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+
+	expected := []TagEvent{
+		{Type: TagDeleted, Tag: "0.0.1", PreviousDigest: "sha256:1"},
+		{Type: TagCreated, Tag: "0.0.2", Digest: "sha256:2"},
+		{Type: TagMoved, Tag: "latest", Digest: "sha256:2", PreviousDigest: "sha256:1"},
+	}
+	i := 0
+	for ev := range events {
+		if i >= len(expected) {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+		exp := expected[i]
+		if ev.Type != exp.Type || ev.Tag != exp.Tag || ev.Digest != exp.Digest || ev.PreviousDigest != exp.PreviousDigest || ev.Repository != "chryscloud/chrysedgeproxy" {
+			t.Fatalf("expected %+v, got %+v", exp, ev)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("expected %v events, got %v", len(expected), i)
+	}
+}
+
+func TestWatcherFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tags.json")
+
+	hub := &fakeHub{tags: map[string]string{"latest": "sha256:1"}}
+	w := NewWatcher(hub, WatchDigests(true), WatchStore(NewFileTagStore(path)))
+	w.Watch("chryscloud/chrysedgeproxy", "latest")
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+
+	// restart with persisted state
+	hub.set("latest", "sha256:2")
+	w = NewWatcher(hub, WatchDigests(true), WatchStore(NewFileTagStore(path)))
+	w.Watch("chryscloud/chrysedgeproxy", "latest")
+	events := w.Subscribe()
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %v", len(events))
+	}
+	if ev := <-events; ev.Type != TagMoved || ev.Digest != "sha256:2" {
+		t.Fatalf("expected latest to be moved, got %+v", ev)
+	}
+	w.Stop()
+}
+
+func TestWatcherSavesAfterDelivery(t *testing.T) {
+	hub := &fakeHub{tags: map[string]string{"latest": "sha256:1"}}
+	store := NewMemoryTagStore()
+	w := NewWatcher(hub, WatchDigests(true), WatchStore(store), WatchBuffer(1))
+	w.Watch("chryscloud/chrysedgeproxy")
+	full := w.Subscribe()
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second event doesn't fit into the subscriber channel until the watcher is stopped
+	hub.set("0.0.1", "sha256:1")
+	hub.set("0.0.2", "sha256:2")
+	polled := make(chan error)
+	go func() {
+		polled <- w.Poll()
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(full) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	w.Stop()
+	if err := <-polled; err != nil {
+		t.Fatal(err)
+	}
+
+	// undelivered changes are reported again after restart
+	w = NewWatcher(hub, WatchDigests(true), WatchStore(store))
+	w.Watch("chryscloud/chrysedgeproxy")
+	events := w.Subscribe()
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events reported again, got %v", len(events))
+	}
+	w.Stop()
+}
+
+// tagsOnlyHub implements only the DockerHub interface
+type tagsOnlyHub struct {
+	hub *fakeHub
+}
+
+func (h tagsOnlyHub) Tags(repository string) ([]string, error) {
+	return h.hub.Tags(repository)
+}
+
+func TestWatcherTagsOnlyHub(t *testing.T) {
+	hub := tagsOnlyHub{&fakeHub{tags: map[string]string{"latest": "sha256:1"}}}
+	w := NewWatcher(hub)
+	w.Watch("chryscloud/chrysedgeproxy")
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = NewWatcher(hub, WatchDigests(true))
+	w.Watch("chryscloud/chrysedgeproxy")
+	if err := w.Poll(); err != ErrRegistryClient {
+		t.Fatalf("expected ErrRegistryClient, got %v", err)
+	}
+}
+
+func TestWatcherWatchListChange(t *testing.T) {
+	hub := &fakeHub{tags: map[string]string{"latest": "sha256:1", "0.0.1": "sha256:1", "0.0.2": "sha256:2"}}
+	w := NewWatcher(hub, WatchDigests(true))
+	w.Watch("chryscloud/chrysedgeproxy", "latest", "0.0.1")
+	events := w.Subscribe()
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.0.1 is no longer watched and 0.0.2 is newly watched, both still exist
+	w.Watch("chryscloud/chrysedgeproxy", "latest", "0.0.2")
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events after watch list change, got %+v", <-events)
+	}
+
+	hub.set("0.0.2", "")
+	hub.set("latest", "sha256:3")
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+	var got []TagEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 2 || got[0].Type != TagDeleted || got[0].Tag != "0.0.2" || got[1].Type != TagMoved || got[1].Tag != "latest" {
+		t.Fatalf("expected 0.0.2 deleted and latest moved, got %+v", got)
+	}
+}
@@ -23,3 +23,18 @@ type UserClaim struct {
 	Enabled bool     `json:"enabled"`
 	jwt.StandardClaims
 }
+
+// GetID user ID
+func (u UserClaim) GetID() string {
+	return u.ID
+}
+
+// GetRoles roles of the user (auth.RequireRoles)
+func (u UserClaim) GetRoles() []string {
+	return u.Roles
+}
+
+// IsEnabled false for disabled users (auth.RequireRoles rejects them)
+func (u UserClaim) IsEnabled() bool {
+	return u.Enabled
+}